@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// auth grant
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantPassword          = "password"
+	GrantTask              = "task"
+)
+
+// AuthConfig how users obtain and refresh the token of use_token tasks.
+// the token is the ACCESS_TOKEN param, refreshed when it expire or a use_token task get 401, then the task is retried once.
+type AuthConfig struct {
+	Grant        string `json:"grant"`
+	TokenURL     string `json:"token_url"`
+	Host         string `json:"host"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	Scope        string `json:"scope"`
+
+	// Tasks of the task grant. they set [ACCESS_TOKEN] like a login of pre.
+	Tasks []*Task `json:"tasks"`
+	// ExpiresSec token lifetime when the token response has no expires_in. 0 refresh only on 401.
+	ExpiresSec int `json:"expires_sec"`
+	// RefreshBeforeSec refresh this long before the expiry.
+	RefreshBeforeSec int `json:"refresh_before_sec"`
+
+	// Header of the token, Authorization by default.
+	Header string `json:"header"`
+	// TokenType prefix of the token value, Bearer by default for the Authorization header.
+	TokenType string `json:"token_type"`
+}
+
+func (s *Scenario) authTasks() []*Task {
+	if s.Auth == nil {
+		return nil
+	}
+	return s.Auth.Tasks
+}
+
+// tokenHeader return the header name and value of the token.
+func (a *AuthConfig) tokenHeader(token string) (string, string) {
+	if a == nil || a.Header == "" || a.Header == "Authorization" {
+		tokenType := "Bearer"
+		if a != nil && a.TokenType != "" {
+			tokenType = a.TokenType
+		}
+		return "Authorization", tokenType + " " + token
+	}
+	if a.TokenType != "" {
+		return a.Header, a.TokenType + " " + token
+	}
+	return a.Header, token
+}
+
+// tokenExpired true if the user has no token or it expire within refresh_before_sec.
+func (u *User) tokenExpired() bool {
+	if u.param["ACCESS_TOKEN"] == "" {
+		return true
+	}
+	if u.tokenExpiry.IsZero() {
+		return false
+	}
+	before := time.Duration(u.auth.RefreshBeforeSec) * time.Second
+	return !time.Now().Add(before).Before(u.tokenExpiry)
+}
+
+// doAuthTask run the task. with the auth section a use_token task first refresh an expired token,
+// and on 401 refresh the token and retry once. report send the refresh stats titled "auth".
+func (u *User) doAuthTask(task *Task, reqURL string, tr *TraceEntry, report bool) (*reqResult, error) {
+	if !task.UseToken || u.auth == nil {
+		return u.doTask(task, reqURL, u.makeHeader(task.UseToken), tr)
+	}
+
+	if u.tokenExpired() {
+		if err := u.refreshToken(report); err != nil {
+			return nil, err
+		}
+	}
+	res, err := u.doTask(task, reqURL, u.makeHeader(true), tr)
+	if err == nil || res == nil || res.status != http.StatusUnauthorized {
+		return res, err
+	}
+
+	if err := u.refreshToken(report); err != nil {
+		return res, err
+	}
+	return u.doTask(task, reqURL, u.makeHeader(true), tr)
+}
+
+// refreshToken get a new token by the grant, by the refresh_token when the last token response had one.
+func (u *User) refreshToken(report bool) error {
+	start := time.Now()
+	var res *reqResult
+	var err error
+	if u.auth.Grant == GrantTask {
+		res, err = u.runAuthTasks()
+	} else {
+		res, err = u.requestToken()
+	}
+	if err == nil {
+		u.tokenExpiry = time.Time{}
+		if u.auth.ExpiresSec > 0 {
+			u.tokenExpiry = start.Add(time.Duration(u.auth.ExpiresSec) * time.Second)
+		}
+		if res != nil {
+			if sec, ok := res.parsed["expires_in"].(float64); ok && sec > 0 {
+				u.tokenExpiry = start.Add(time.Duration(sec) * time.Second)
+			}
+		}
+	}
+
+	if report {
+		stats := &RequesterStats{Title: "auth", MinRequestTime: time.Minute}
+		reqURL := u.tokenURL()
+		if err != nil {
+			stats.Err(err)
+			if res != nil {
+				stats.Wire(res)
+			}
+		} else if res != nil {
+			stats.Calc(res)
+		}
+		u.sendStats(stats, reqURL)
+	}
+	if err != nil {
+		log.Printf("[%04d] auth refresh err=%s\n", u.idx, err)
+		return fmt.Errorf("auth %w", err)
+	}
+	return nil
+}
+
+func (u *User) tokenURL() string {
+	if u.auth.Grant == GrantTask {
+		if len(u.auth.Tasks) == 0 {
+			return ""
+		}
+		return u.requestURL(u.auth.Tasks[len(u.auth.Tasks)-1])
+	}
+	return u.taskURL(&Task{URL: u.auth.TokenURL, Host: u.auth.Host})
+}
+
+// requestToken post the oauth2 token request. a failed refresh_token grant fall back to the grant.
+func (u *User) requestToken() (*reqResult, error) {
+	a := u.auth
+	body := map[string]string{"grant_type": a.Grant}
+	if a.ClientID != "" {
+		body["client_id"] = u.replaceTemplate(a.ClientID)
+	}
+	if a.ClientSecret != "" {
+		body["client_secret"] = u.replaceTemplate(a.ClientSecret)
+	}
+	if a.Scope != "" {
+		body["scope"] = a.Scope
+	}
+	if a.Grant == GrantPassword {
+		body["username"] = u.replaceTemplate(a.Username)
+		body["password"] = u.replaceTemplate(a.Password)
+	}
+
+	reqURL := u.tokenURL()
+	if refresh := u.param["REFRESH_TOKEN"]; refresh != "" {
+		refreshBody := map[string]string{"grant_type": "refresh_token", "refresh_token": refresh}
+		for _, key := range []string{"client_id", "client_secret", "scope"} {
+			if val, ok := body[key]; ok {
+				refreshBody[key] = val
+			}
+		}
+		if res, err := u.postToken(reqURL, refreshBody); err == nil {
+			return res, nil
+		}
+		delete(u.param, "REFRESH_TOKEN")
+	}
+	return u.postToken(reqURL, body)
+}
+
+func (u *User) postToken(reqURL string, body map[string]string) (*reqResult, error) {
+	tr := u.newTrace("auth")
+	res, err := doRequest(u.client, reqURL, "POST", nil, nil, body, nil, "", tr)
+	if err == nil {
+		token, _ := res.parsed["access_token"].(string)
+		if token == "" {
+			err = fmt.Errorf("token response without access_token")
+		} else {
+			u.param["ACCESS_TOKEN"] = token
+			if refresh, ok := res.parsed["refresh_token"].(string); ok && refresh != "" {
+				u.param["REFRESH_TOKEN"] = refresh
+			}
+		}
+	}
+	u.endTrace(tr, nil, err)
+	return res, err
+}
+
+// runAuthTasks run the tasks of the task grant. the result is of the last task.
+func (u *User) runAuthTasks() (*reqResult, error) {
+	// the old token must not pass for a new one when the tasks do not set it.
+	delete(u.param, "ACCESS_TOKEN")
+	var res *reqResult
+	for _, task := range u.auth.Tasks {
+		reqURL := u.requestURL(task)
+		tr := u.newTrace("auth " + task.Step)
+		var err error
+		res, err = u.doTask(task, reqURL, u.makeHeader(false), tr)
+		if err != nil {
+			u.endTrace(tr, nil, err)
+			return res, err
+		}
+		u.setParam(task.SetParam, res.parsed, reqURL)
+		u.endTrace(tr, task.SetParam, nil)
+	}
+	if u.param["ACCESS_TOKEN"] == "" {
+		return res, fmt.Errorf("auth tasks did not set ACCESS_TOKEN")
+	}
+	return res, nil
+}
+
+// checkAuth check the auth section. it is checked at the first use_token task, as the tasks may use the params of pre before it.
+func (v *validator) checkAuth(a *AuthConfig) {
+	switch a.Grant {
+	case GrantClientCredentials, GrantPassword:
+		if a.TokenURL == "" {
+			v.errorf("auth: %s grant without token_url", a.Grant)
+		}
+		if a.Host != "" {
+			if _, ok := v.hosts[a.Host]; !ok {
+				v.errorf("auth: unknown host %q", a.Host)
+			}
+		}
+		if a.Grant == GrantPassword && (a.Username == "" || a.Password == "") {
+			v.errorf("auth: password grant without username or password")
+		}
+		v.checkRefs("auth", "client_id", a.ClientID)
+		v.checkRefs("auth", "client_secret", a.ClientSecret)
+		v.checkRefs("auth", "username", a.Username)
+		v.checkRefs("auth", "password", a.Password)
+		if len(a.Tasks) > 0 {
+			v.errorf("auth: tasks only with the task grant")
+		}
+		v.defined["REFRESH_TOKEN"] = true
+	case GrantTask:
+		if len(a.Tasks) == 0 {
+			v.errorf("auth: task grant without tasks")
+		}
+		sets := false
+		for i, task := range a.Tasks {
+			v.checkTask(task, "auth.tasks", i, false)
+			if _, ok := task.SetParam["[ACCESS_TOKEN]"]; ok {
+				sets = true
+			}
+		}
+		if len(a.Tasks) > 0 && !sets {
+			v.errorf("auth: tasks do not set [ACCESS_TOKEN]")
+		}
+	default:
+		v.errorf("auth: invalid grant %q", a.Grant)
+	}
+	if a.ExpiresSec < 0 || a.RefreshBeforeSec < 0 {
+		v.errorf("auth: expires_sec and refresh_before_sec must not be negative")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuthRefresh(t *testing.T) {
+	var mu sync.Mutex
+	var grants []string
+	valid := ""
+	issued := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/token":
+			r.ParseForm()
+			grants = append(grants, r.PostForm.Get("grant_type"))
+			if r.PostForm.Get("client_id") != "bust" || (r.PostForm.Get("grant_type") == "refresh_token" && r.PostForm.Get("refresh_token") != "r1") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			issued++
+			valid = fmt.Sprintf("tok%d", issued)
+			fmt.Fprintf(w, `{"access_token":"%s","refresh_token":"r1","expires_in":3600}`, valid)
+		case "/api":
+			if r.Header.Get("Authorization") != "Bearer "+valid {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"ok":1}`))
+		}
+	}))
+	defer srv.Close()
+
+	u := &User{param: map[string]string{}, client: newHTTPClient(),
+		auth: &AuthConfig{Grant: GrantClientCredentials, TokenURL: srv.URL + "/token", ClientID: "bust", ClientSecret: "secret"}}
+	task := &Task{URL: srv.URL + "/api", UseToken: true}
+	run := func() {
+		if _, err := u.doAuthTask(task, task.URL, nil, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run()
+	if u.param["ACCESS_TOKEN"] != "tok1" || time.Until(u.tokenExpiry) < 59*time.Minute {
+		t.Errorf("token not obtained %v expiry %v", u.param, u.tokenExpiry)
+	}
+
+	// the server revoke the token: 401, refresh by the refresh_token, retry.
+	mu.Lock()
+	valid = "revoked"
+	mu.Unlock()
+	run()
+
+	// expired: refresh before the request.
+	u.tokenExpiry = time.Now().Add(-time.Second)
+	run()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(grants, ",") != "client_credentials,refresh_token,refresh_token" || u.param["ACCESS_TOKEN"] != "tok3" {
+		t.Error("grants", grants, u.param)
+	}
+}
+
+func TestAuthTaskGrant(t *testing.T) {
+	logins := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			logins++
+			if logins >= 10 {
+				w.Write([]byte(`{}`))
+				return
+			}
+			fmt.Fprintf(w, `{"token":"t%d"}`, logins)
+		case "/api":
+			if r.Header.Get("X-Token") != fmt.Sprintf("t%d", logins) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	u := &User{param: map[string]string{}, client: newHTTPClient(), auth: &AuthConfig{
+		Grant:      GrantTask,
+		Tasks:      []*Task{{URL: srv.URL + "/login", Method: "POST", SetParam: map[string]string{"[ACCESS_TOKEN]": "token"}}},
+		ExpiresSec: 60,
+		Header:     "X-Token",
+	}}
+	task := &Task{URL: srv.URL + "/api", UseToken: true}
+	for i := 0; i < 2; i++ {
+		if _, err := u.doAuthTask(task, task.URL, nil, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if logins != 1 || u.tokenExpiry.IsZero() {
+		t.Error("token not reused", logins, u.tokenExpiry)
+	}
+
+	logins = 5
+	if _, err := u.doAuthTask(task, task.URL, nil, false); err != nil || u.param["ACCESS_TOKEN"] != "t6" {
+		t.Error("401 not refreshed", err, u.param)
+	}
+
+	// the login answer without token: the old token must not be kept.
+	logins = 9
+	u.tokenExpiry = time.Now().Add(-time.Second)
+	if _, err := u.doAuthTask(task, task.URL, nil, false); err == nil || !strings.Contains(err.Error(), "did not set ACCESS_TOKEN") {
+		t.Error("stale token kept", err, u.param)
+	}
+}
+
+func TestValidateAuth(t *testing.T) {
+	s := &Scenario{
+		Auth: &AuthConfig{Grant: GrantPassword, TokenURL: "/token", Username: "[USER_ID]", Password: "[PASSWORD]"},
+		Pre:  []*Task{{Step: "profile", URL: "/me", UseToken: true, SetParam: map[string]string{"[USER_ID]": "id"}}},
+		Run:  []*Task{{Step: "info", URL: "/info", UseToken: true}},
+	}
+	// [USER_ID] is set by the use_token task, after the token is obtained.
+	errs := s.Validate()
+	if len(errs) != 2 || !strings.Contains(errs[0].Error(), "auth: username: undefined param [USER_ID]") ||
+		!strings.Contains(errs[1].Error(), "auth: password: undefined param [PASSWORD]") {
+		t.Error("password grant problems", errs)
+	}
+
+	s.Pre = []*Task{{Step: "signup", URL: "/signup", Method: "POST", SetParam: map[string]string{"[USER_ID]": "id", "[PASSWORD]": "pw"}}, s.Pre[0]}
+	if errs := s.Validate(); len(errs) != 0 {
+		t.Error("params of pre before use_token not defined", errs)
+	}
+	s.Pre = s.Pre[1:]
+
+	s.Auth = &AuthConfig{Grant: GrantTask, Tasks: []*Task{{URL: "/login"}}}
+	errs = s.Validate()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "tasks do not set [ACCESS_TOKEN]") {
+		t.Error("task grant problems", errs)
+	}
+
+	s.Auth = &AuthConfig{Grant: "implicit"}
+	if errs := s.Validate(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "invalid grant") {
+		t.Error("grant problems", errs)
+	}
+}
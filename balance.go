@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// load balance strategy
+const (
+	LBRoundRobin = "roundrobin"
+	LBRandom     = "random"
+	LBSticky     = "sticky"
+)
+
+// Balancer distribute requests over the target addresses given by -s.
+type Balancer struct {
+	addrs    []string
+	strategy string
+	next     uint32
+}
+
+// NewBalancer parse "addr1,addr2" or "@hosts file" and return the balancer.
+func NewBalancer(srv string, strategy string) (*Balancer, error) {
+	switch strategy {
+	case LBRoundRobin, LBRandom, LBSticky:
+	default:
+		return nil, fmt.Errorf("not support lb strategy %q", strategy)
+	}
+
+	var addrs []string
+	if strings.HasPrefix(srv, "@") {
+		var err error
+		if addrs, err = readAddrFile(srv[1:]); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, addr := range strings.Split(srv, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("empty server addr %q", srv)
+	}
+
+	return &Balancer{
+		addrs:    addrs,
+		strategy: strategy,
+	}, nil
+}
+
+// readAddrFile read one address per line. empty line and # comment are skipped.
+func readAddrFile(filePath string) ([]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var addrs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	return addrs, scanner.Err()
+}
+
+// Multi ...
+func (b *Balancer) Multi() bool {
+	return len(b.addrs) > 1
+}
+
+// Pick return the target address for the user request.
+func (b *Balancer) Pick(userIdx uint32) string {
+	if len(b.addrs) == 1 {
+		return b.addrs[0]
+	}
+	switch b.strategy {
+	case LBRandom:
+		return b.addrs[rand.Intn(len(b.addrs))]
+	case LBSticky:
+		return b.addrs[userIdx%uint32(len(b.addrs))]
+	default:
+		return b.addrs[(atomic.AddUint32(&b.next, 1)-1)%uint32(len(b.addrs))]
+	}
+}
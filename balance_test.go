@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestBalancer(t *testing.T) {
+	b, err := NewBalancer("http://a, http://b,http://c", LBRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"http://a", "http://b", "http://c", "http://a"} {
+		if addr := b.Pick(1); addr != want {
+			t.Error("roundrobin err", i, addr)
+		}
+	}
+
+	b.strategy = LBSticky
+	if b.Pick(4) != b.Pick(4) || b.Pick(4) != "http://b" {
+		t.Error("sticky err", b.Pick(4))
+	}
+
+	if _, err := NewBalancer("http://a", "weight"); err == nil {
+		t.Error("unknown strategy not rejected")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// bind mode
+const (
+	BindPerConn = "conn"
+	BindPerUser = "user"
+)
+
+const maxBindIPs = 65536
+
+var (
+	bindIPs  []net.IP
+	bindNext uint32
+)
+
+// interfaceAddrs the addresses of this host, replaced by tests.
+var interfaceAddrs = net.InterfaceAddrs
+
+// initBind parse -bind "ip,ip,cidr" into bindIPs. every ip must be an address of this host,
+// so EADDRNOTAVAIL at run is only the port exhaustion.
+func initBind() error {
+	bindIPs = nil
+	switch bindMode {
+	case BindPerConn:
+	case BindPerUser:
+		if bindAddr != "" && connMode != ConnPerUser {
+			return fmt.Errorf("-bind-mode user needs -conn-mode user")
+		}
+	default:
+		return fmt.Errorf("not support bind mode %q", bindMode)
+	}
+	if bindAddr == "" {
+		return nil
+	}
+
+	for _, item := range strings.Split(bindAddr, ",") {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return fmt.Errorf("bind: invalid ip %q", item)
+			}
+			bindIPs = append(bindIPs, ip)
+			continue
+		}
+
+		ip, ipnet, err := net.ParseCIDR(item)
+		if err != nil {
+			return fmt.Errorf("bind: %s", err)
+		}
+		var ips []net.IP
+		for ip = ip.Mask(ipnet.Mask); ipnet.Contains(ip); ip = nextIP(ip) {
+			if len(bindIPs)+len(ips) >= maxBindIPs {
+				return fmt.Errorf("bind: more than %d addresses", maxBindIPs)
+			}
+			ips = append(ips, ip)
+		}
+		// the network and broadcast address of an ipv4 subnet are not host addresses.
+		if ones, bits := ipnet.Mask.Size(); bits == 32 && ones < 31 {
+			ips = ips[1 : len(ips)-1]
+		}
+		bindIPs = append(bindIPs, ips...)
+	}
+	return checkBindIPs()
+}
+
+// checkBindIPs check that every bind ip is an address of this host.
+func checkBindIPs() error {
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("bind: %s", err)
+	}
+	local := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		switch a := addr.(type) {
+		case *net.IPNet:
+			local[a.IP.String()] = true
+		case *net.IPAddr:
+			local[a.IP.String()] = true
+		}
+	}
+	for _, ip := range bindIPs {
+		if !local[ip.String()] {
+			return fmt.Errorf("bind: %s is not an address of this host", ip)
+		}
+	}
+	return nil
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// nextBindIP return the next source ip in round robin, nil without -bind.
+func nextBindIP() net.IP {
+	if len(bindIPs) == 0 {
+		return nil
+	}
+	return bindIPs[(atomic.AddUint32(&bindNext, 1)-1)%uint32(len(bindIPs))]
+}
+
+// isPortExhausted true if err is EADDRNOTAVAIL, no free local port for the source ip.
+func isPortExhausted(err error) bool {
+	return errors.Is(err, syscall.EADDRNOTAVAIL)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestInitBind(t *testing.T) {
+	bindAddr, bindMode = "10.0.0.1, 10.0.1.0/30, 10.0.2.0/31", BindPerConn
+	interfaceAddrs = func() ([]net.Addr, error) {
+		var addrs []net.Addr
+		for _, ip := range []string{"127.0.0.1", "10.0.0.1", "10.0.1.1", "10.0.1.2", "10.0.2.0", "10.0.2.1"} {
+			addrs = append(addrs, &net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(24, 32)})
+		}
+		return addrs, nil
+	}
+	defer func() { bindAddr, bindIPs, interfaceAddrs = "", nil, net.InterfaceAddrs }()
+
+	if err := initBind(); err != nil {
+		t.Fatal(err)
+	}
+	// a /30 has no network and broadcast address, a /31 has two hosts.
+	want := []string{"10.0.0.1", "10.0.1.1", "10.0.1.2", "10.0.2.0", "10.0.2.1"}
+	if len(bindIPs) != len(want) {
+		t.Fatal("bind ips err", bindIPs)
+	}
+	for i, w := range want {
+		if ip := nextBindIP(); ip.String() != w {
+			t.Error("next bind ip err", i, ip)
+		}
+	}
+
+	bindAddr = "10.0.0.1, 10.0.3.7"
+	if err := initBind(); err == nil || err.Error() != "bind: 10.0.3.7 is not an address of this host" {
+		t.Error("foreign ip not rejected", err)
+	}
+}
+
+func TestPortExhaustedCategory(t *testing.T) {
+	err := fmt.Errorf("dial: %w", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.EADDRNOTAVAIL)})
+
+	stats := &RequesterStats{}
+	stats.Err(err)
+	stats.Err(fmt.Errorf("resp status code err=500"))
+	if stats.NumErrs != 2 || stats.ErrCategories[ErrPortExhausted] != 1 {
+		t.Error("category err", stats.NumErrs, stats.ErrCategories)
+	}
+}
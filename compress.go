@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// content encoding
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+	EncodingBrotli  = "br"
+)
+
+// encodeBody compress a request body by encoding.
+func encodeBody(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case EncodingGzip:
+		w = gzip.NewWriter(&buf)
+	case EncodingDeflate:
+		w = zlib.NewWriter(&buf)
+	case EncodingBrotli:
+		w = brotli.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("not support compress %q", encoding)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// checkAcceptEncoding check the -accept-encoding list, like "gzip, br;q=0.8". only decodable codings are allowed.
+func checkAcceptEncoding(list string) error {
+	if list == "" {
+		return nil
+	}
+	for _, coding := range strings.Split(list, ",") {
+		name := strings.ToLower(strings.TrimSpace(strings.Split(coding, ";")[0]))
+		switch name {
+		case EncodingGzip, EncodingDeflate, EncodingBrotli, "identity":
+		default:
+			return fmt.Errorf("not support accept encoding %q, want gzip, deflate, br or identity", name)
+		}
+	}
+	return nil
+}
+
+// decodeBody decompress a response body by its Content-Encoding. several codings are undone in reverse order.
+func decodeBody(encoding string, data []byte) ([]byte, error) {
+	codings := strings.Split(encoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		if data, err = decodeCoding(codings[i], data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func decodeCoding(encoding string, data []byte) ([]byte, error) {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if len(data) == 0 || encoding == "" || encoding == "identity" {
+		return data, nil
+	}
+
+	var r io.Reader
+	switch encoding {
+	case EncodingGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	case EncodingDeflate:
+		// deflate should be zlib format, but some servers send raw deflate.
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			fr := flate.NewReader(bytes.NewReader(data))
+			defer fr.Close()
+			r = fr
+		} else {
+			defer zr.Close()
+			r = zr
+		}
+	case EncodingBrotli:
+		r = brotli.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("not support content encoding %q", encoding)
+	}
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	for _, encoding := range []string{EncodingGzip, EncodingDeflate, EncodingBrotli} {
+		data, err := encodeBody(encoding, []byte("name=bust&pass=wrk"))
+		if err != nil {
+			t.Fatal(encoding, err)
+		}
+		decoded, err := decodeBody(encoding, data)
+		if err != nil || string(decoded) != "name=bust&pass=wrk" {
+			t.Errorf("%s round trip %q err=%v", encoding, decoded, err)
+		}
+	}
+	if _, err := encodeBody("zip", nil); err == nil {
+		t.Error("bad encoding not rejected")
+	}
+
+	// Content-Encoding: gzip, br is gzip then br, decoded br first.
+	data, _ := encodeBody(EncodingGzip, []byte("layered"))
+	data, _ = encodeBody(EncodingBrotli, data)
+	if decoded, err := decodeBody("gzip, br", data); err != nil || string(decoded) != "layered" {
+		t.Errorf("multi coding %q err=%v", decoded, err)
+	}
+}
+
+func TestCheckAcceptEncoding(t *testing.T) {
+	for _, list := range []string{"", "gzip", "gzip, br;q=0.8, deflate", "identity"} {
+		if err := checkAcceptEncoding(list); err != nil {
+			t.Errorf("%q rejected %s", list, err)
+		}
+	}
+	for _, list := range []string{"zstd", "gzip, *"} {
+		if err := checkAcceptEncoding(list); err == nil {
+			t.Errorf("%q not rejected", list)
+		}
+	}
+}
+
+func TestCompressRequest(t *testing.T) {
+	reply := `{"result":"` + strings.Repeat("a", 1000) + `"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		body, err := decodeBody(r.Header.Get("Content-Encoding"), body)
+		if err != nil || string(body) != "id=1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := encodeBody(EncodingBrotli, []byte(reply))
+		w.Header().Set("Content-Encoding", EncodingBrotli)
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: newTLSTransport(&tls.Config{}, newDialFunc(nil))}
+	res, err := doRequest(client, srv.URL, "POST", nil, nil, map[string]string{"id": "1"}, nil, EncodingGzip, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.parsed["result"] != strings.Repeat("a", 1000) {
+		t.Error("response not decoded")
+	}
+	if res.recv >= int64(res.respSize) {
+		t.Errorf("recv %d not less than decoded %d", res.recv, res.respSize)
+	}
+}
@@ -1,34 +1,103 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
+	"net"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
 )
 
 // Task ...
 type Task struct {
-	Step     string            `json:"step"`
-	URL      string            `json:"url"`
-	Method   string            `json:"method"`
-	URLParam map[string]string `json:"url_param"`
-	Body     map[string]string `json:"body"`
-	SetParam map[string]string `json:"set_param"`
-	UseToken bool              `json:"use_token"`
-	IsOnce   bool              `json:"is_once"`
-	WaitSec  string            `json:"wait_sec"`
+	Step     string               `json:"step"`
+	URL      string               `json:"url"`
+	Method   string               `json:"method"`
+	URLParam map[string]string    `json:"url_param"`
+	Body     map[string]string    `json:"body"`
+	SetParam map[string]string    `json:"set_param"`
+	UseToken bool                 `json:"use_token"`
+	IsOnce   bool                 `json:"is_once"`
+	WaitSec  string               `json:"wait_sec"`
+	Host     string               `json:"host"`
+	Compress string               `json:"compress"`
+	Files    map[string]*FilePart `json:"files"`
+
+	Type       string            `json:"type"`
+	Message    string            `json:"message"`
+	Binary     bool              `json:"binary"`
+	Match      map[string]string `json:"match"`
+	TimeoutSec int               `json:"timeout_sec"`
+
+	Events      int    `json:"events"`
+	DurationSec int    `json:"duration_sec"`
+	Call        string `json:"call"`
+
+	Encoding     string            `json:"encoding"`
+	Delimiter    string            `json:"delimiter"`
+	LengthPrefix int               `json:"length_prefix"`
+	Fields       map[string]string `json:"fields"`
+
+	Query     string                 `json:"query"`
+	Operation string                 `json:"operation"`
+	Variables map[string]interface{} `json:"variables"`
+
+	Use  string            `json:"use"`
+	With map[string]string `json:"with"`
+
+	origin string
 }
 
 // Scenario ...
 type Scenario struct {
-	Param   map[string]string `json:"param"`
-	Pre     []*Task           `json:"pre"`
-	Run     []*Task           `json:"run"`
-	PreStep []*Task           `json:"pre_step"`
+	Include   map[string]string       `json:"include"`
+	Fragments map[string][]*Task      `json:"fragments"`
+	Env       map[string]*Environment `json:"env"`
+	Hosts     map[string]string       `json:"hosts"`
+	TLS       *TLSConfig              `json:"tls"`
+	HostTLS   map[string]*TLSConfig   `json:"host_tls"`
+	Resolve   map[string]string       `json:"resolve"`
+	DNSServer string                  `json:"dns_server"`
+	Param     map[string]string       `json:"param"`
+	Pre       []*Task                 `json:"pre"`
+	Run       []*Task                 `json:"run"`
+	PreStep   []*Task                 `json:"pre_step"`
+
+	// ClearCookies start every run iteration with only the cookies set by pre.
+	ClearCookies bool `json:"clear_cookies"`
+	// DescriptorSet protoc -o file of grpc tasks, instead of server reflection.
+	DescriptorSet string `json:"descriptor_set"`
+	// Auth obtain and refresh the token of use_token tasks.
+	Auth *AuthConfig `json:"auth"`
+
+	file     string
+	includes map[string]*Scenario
+	header   map[string]string
+}
+
+// usesHosts true if any task target other than -s, or -s has several servers.
+func (s *Scenario) usesHosts() bool {
+	if len(s.Hosts) > 0 || (balancer != nil && balancer.Multi()) {
+		return true
+	}
+	for _, tasks := range [][]*Task{s.Pre, s.Run, s.PreStep, s.authTasks()} {
+		for _, task := range tasks {
+			if isAbsURL(task.URL) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // IsPre ...
@@ -40,10 +109,24 @@ var curStep, maxStep int
 
 // newUser
 func (s *Scenario) newUser() *User {
+	// -bind-mode user give every user a source ip.
+	var local net.IP
+	if bindMode == BindPerUser {
+		local = nextBindIP()
+	}
 	user := &User{
-		idx:   atomic.AddUint32(&usrIdx, 1),
-		param: make(map[string]string),
+		idx:       atomic.AddUint32(&usrIdx, 1),
+		param:     make(map[string]string),
+		header:    s.header,
+		hosts:     s.Hosts,
+		hostStats: s.usesHosts(),
+		client:    newUserClient(local),
+		local:     local,
+
+		clearCookies: s.ClearCookies,
+		auth:         s.Auth,
 	}
+	user.resetCookies()
 	for key, val := range s.Param {
 		startIdx := strings.Index(val, "[")
 		endIdx := strings.Index(val, "]")
@@ -77,15 +160,138 @@ func (s *Scenario) newUser() *User {
 
 // LoadConfig ...
 func LoadConfig(filePath string) (*Scenario, error) {
+	loader := &scenarioLoader{loaded: make(map[string]*Scenario)}
+	scenario, err := loader.load(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := scenario.expandAll(); err != nil {
+		return nil, err
+	}
+	if err := scenario.checkLoad(); err != nil {
+		return nil, err
+	}
+	if err := scenario.loadFiles(); err != nil {
+		return nil, err
+	}
+	return scenario, nil
+}
+
+func readScenario(filePath string) (*Scenario, error) {
 	data, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
 
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml":
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("%s: %s", filePath, err)
+		}
+	}
+
 	scenario := &Scenario{}
-	if err := json.Unmarshal(data, scenario); err != nil {
-		return nil, err
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(scenario); err != nil {
+		return nil, fmt.Errorf("%s: %s", filePath, err)
 	}
+	scenario.file = filePath
 
+	dir := filepath.Dir(filePath)
+	if scenario.TLS != nil {
+		scenario.TLS.resolvePath(dir)
+	}
+	for _, c := range scenario.HostTLS {
+		c.resolvePath(dir)
+	}
+	if scenario.DescriptorSet != "" && !filepath.IsAbs(scenario.DescriptorSet) {
+		scenario.DescriptorSet = filepath.Join(dir, scenario.DescriptorSet)
+	}
+	for _, tasks := range [][]*Task{scenario.Pre, scenario.Run, scenario.PreStep, scenario.authTasks()} {
+		resolveFilePath(tasks, dir)
+	}
+	for _, tasks := range scenario.Fragments {
+		resolveFilePath(tasks, dir)
+	}
 	return scenario, nil
 }
+
+// yamlToJSON convert yaml document to json so both formats share the json tags of Scenario.
+// plain scalars like 5 or true become strings where the Scenario field is a string.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(yamlValue(doc, reflect.TypeOf(Scenario{})))
+}
+
+// yamlValue return the yaml node as the json value of type t. map keys are always strings.
+func yamlValue(node interface{}, t reflect.Type) interface{} {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch v := node.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			result[key] = yamlValue(val, yamlFieldType(t, key))
+		}
+		return result
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			keyStr := yamlScalar(key)
+			result[keyStr] = yamlValue(val, yamlFieldType(t, keyStr))
+		}
+		return result
+	case []interface{}:
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = yamlValue(item, elem)
+		}
+		return result
+	case nil, string:
+		return node
+	}
+	if t != nil && t.Kind() == reflect.String {
+		return yamlScalar(node)
+	}
+	return node
+}
+
+// yamlFieldType return the type of key in a struct by json tag, or the element type of a map.
+func yamlFieldType(t reflect.Type, key string) reflect.Type {
+	if t == nil {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem()
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if strings.Split(f.Tag.Get("json"), ",")[0] == key {
+				return f.Type
+			}
+		}
+	}
+	return nil
+}
+
+func yamlScalar(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(val)
+}
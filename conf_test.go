@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigYAML(t *testing.T) {
+	dir := t.TempDir()
+	mainFile := writeScenario(t, dir, "main.yaml", `
+# comment
+param:
+  USER_ID: tester
+run:
+  - step: info
+    url: /info/[USER_ID]
+    use_token: true
+`)
+
+	s, err := LoadConfig(mainFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Run) != 1 || s.Run[0].URL != "/info/[USER_ID]" || !s.Run[0].UseToken {
+		t.Errorf("yaml err %+v", s.Run)
+	}
+}
+
+func TestLoadConfigYAMLScalars(t *testing.T) {
+	dir := t.TempDir()
+	mainFile := writeScenario(t, dir, "main.yaml", `
+param:
+  LEVEL: 10
+  RATE: 0.5
+  ADMIN: true
+run:
+  - step: list
+    url: /list
+    wait_sec: 5
+    url_param: {page: 1}
+    body: {1: x}
+    timeout_sec: 3
+    type: graphql
+    query: "{ list }"
+    variables: {n: 2}
+`)
+
+	s, err := LoadConfig(mainFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Param["LEVEL"] != "10" || s.Param["RATE"] != "0.5" || s.Param["ADMIN"] != "true" {
+		t.Error("param err", s.Param)
+	}
+	task := s.Run[0]
+	if task.WaitSec != "5" || task.URLParam["page"] != "1" || task.Body["1"] != "x" || task.TimeoutSec != 3 || task.Variables["n"] != float64(2) {
+		t.Errorf("task err %+v", task)
+	}
+}
+
+func TestLoadConfigUnknownField(t *testing.T) {
+	dir := t.TempDir()
+	mainFile := writeScenario(t, dir, "main.json", `{
+		"run": [{"step": "info", "url": "/info", "use_tokn": true}]
+	}`)
+
+	_, err := LoadConfig(mainFile)
+	if err == nil || !strings.Contains(err.Error(), "use_tokn") {
+		t.Error("unknown field not rejected", err)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	s := &Scenario{
+		Env: map[string]*Environment{
+			"staging": {
+				Header: map[string]string{"X-Env": "staging"},
+				Param:  map[string]string{"HOST": "staging", "LEVEL": "2"},
+			},
+		},
+		Param: map[string]string{"HOST": "dev", "LEVEL": "1", "MODE": "a"},
+	}
+	t.Setenv("BUSTWRK_LEVEL", "3")
+
+	if err := s.ApplyEnv("staging", map[string]string{"MODE": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Param["HOST"] != "staging" || s.Param["LEVEL"] != "3" || s.Param["MODE"] != "b" {
+		t.Error("override err", s.Param)
+	}
+	if s.header["X-Env"] != "staging" {
+		t.Error("header err", s.header)
+	}
+	if err := s.ApplyEnv("prod", nil); err == nil {
+		t.Error("unknown env not rejected")
+	}
+}
+
+func TestLoadConfigEndlessStream(t *testing.T) {
+	dir := t.TempDir()
+	mainFile := writeScenario(t, dir, "main.json", `{"run": [{"step": "feed", "type": "sse", "url": "/feed"}]}`)
+
+	_, err := LoadConfig(mainFile)
+	if err == nil || !strings.Contains(err.Error(), "run[0]: sse without events or duration_sec") {
+		t.Error("endless stream not rejected", err)
+	}
+}
+
+func TestLoadConfigSetParamKey(t *testing.T) {
+	dir := t.TempDir()
+	mainFile := writeScenario(t, dir, "main.json", `{"run": [{"step": "login", "url": "/login", "set_param": {"T": "token"}}]}`)
+
+	_, err := LoadConfig(mainFile)
+	if err == nil || !strings.Contains(err.Error(), `run[0]: set_param key "T" must be [KEY] form`) {
+		t.Error("set_param key not rejected", err)
+	}
+}
+
+func TestLoadConfigLengthPrefix(t *testing.T) {
+	dir := t.TempDir()
+	mainFile := writeScenario(t, dir, "main.json", `{"run": [{"step": "send", "type": "socket_send", "message": "hi", "length_prefix": 9}]}`)
+
+	_, err := LoadConfig(mainFile)
+	if err == nil || !strings.Contains(err.Error(), "run[0]: length_prefix 9 want 1 to 8 bytes") {
+		t.Error("length_prefix not rejected", err)
+	}
+}
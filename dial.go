@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// connOpened number of connections dialed by all transports.
+var connOpened int64
+
+var netDialer = &net.Dialer{
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+}
+
+// resolveMap "host:port" or "host" -> "ip" or "ip:port" by senario resolve and -resolve.
+var resolveMap map[string]string
+
+// initResolve build resolveMap and the dns server of netDialer.
+func initResolve(s *Scenario) error {
+	resolveMap = make(map[string]string)
+	server := dnsServer
+	if s != nil {
+		for from, to := range s.Resolve {
+			resolveMap[from] = to
+		}
+		if server == "" {
+			server = s.DNSServer
+		}
+	}
+	for from, to := range resolveFlags {
+		resolveMap[from] = to
+	}
+
+	netDialer.Resolver = nil
+	if server == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return fmt.Errorf("dns server %q: %s", server, err)
+	}
+	netDialer.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 5 * time.Second}
+			return d.DialContext(ctx, network, server)
+		},
+	}
+	return nil
+}
+
+// resolveAddr return the overridden address of addr.
+func resolveAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	to, ok := resolveMap[addr]
+	if !ok {
+		if to, ok = resolveMap[host]; !ok {
+			return addr
+		}
+	}
+	if _, _, err := net.SplitHostPort(to); err == nil {
+		return to
+	}
+	return net.JoinHostPort(to, port)
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// newDialFunc return the dial of a transport. local nil rotate -bind per connection.
+func newDialFunc(local net.IP) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialContext(ctx, network, addr, local)
+	}
+}
+
+// dialContext dial a new connection from local, count it and its bytes.
+func dialContext(ctx context.Context, network, addr string, local net.IP) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if useSocks(addr) {
+		conn, err = socksDialer.DialContext(ctx, network, resolveAddr(addr))
+	} else {
+		if local == nil {
+			local = nextBindIP()
+		}
+		d := netDialer
+		if local != nil {
+			bound := *netDialer
+			bound.LocalAddr = &net.TCPAddr{IP: local}
+			if strings.HasPrefix(network, "udp") {
+				bound.LocalAddr = &net.UDPAddr{IP: local}
+			}
+			d = &bound
+		}
+		conn, err = d.DialContext(ctx, network, resolveAddr(addr))
+	}
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&connOpened, 1)
+	return newCountConn(ctx, conn), nil
+}
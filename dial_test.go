@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestResolveAddr(t *testing.T) {
+	resolveMap = map[string]string{
+		"api.example.com:443": "10.0.0.5",
+		"cdn.example.com":     "10.0.0.6:8080",
+	}
+	defer func() { resolveMap = nil }()
+
+	for addr, want := range map[string]string{
+		"api.example.com:443": "10.0.0.5:443",
+		"api.example.com:80":  "api.example.com:80",
+		"cdn.example.com:443": "10.0.0.6:8080",
+		"www.example.com:443": "www.example.com:443",
+	} {
+		if dest := resolveAddr(addr); dest != want {
+			t.Error("resolve err", addr, dest)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const envVarPrefix = "BUSTWRK_"
+
+// Environment ...
+type Environment struct {
+	URL     string            `json:"url"`
+	Hosts   map[string]string `json:"hosts"`
+	Resolve map[string]string `json:"resolve"`
+	Header  map[string]string `json:"header"`
+	Param   map[string]string `json:"param"`
+}
+
+// varFlags repeatable -var KEY=VALUE flag.
+type varFlags map[string]string
+
+func (v varFlags) String() string {
+	var list []string
+	for key, val := range v {
+		list = append(list, key+"="+val)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+func (v varFlags) Set(str string) error {
+	idx := strings.Index(str, "=")
+	if idx <= 0 {
+		return fmt.Errorf("invalid %q, want KEY=VALUE", str)
+	}
+	v[str[:idx]] = str[idx+1:]
+	return nil
+}
+
+// ApplyEnv override Param with the selected environment, BUSTWRK_* environment variables and -var in that order.
+func (s *Scenario) ApplyEnv(name string, vars map[string]string) error {
+	if s.Param == nil {
+		s.Param = make(map[string]string)
+	}
+
+	if name != "" {
+		env, ok := s.Env[name]
+		if !ok {
+			var names []string
+			for n := range s.Env {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("%s: unknown env %q, available %v", s.file, name, names)
+		}
+		for key, val := range env.Param {
+			s.Param[key] = val
+		}
+		if s.Hosts == nil && len(env.Hosts) > 0 {
+			s.Hosts = make(map[string]string)
+		}
+		for name, addr := range env.Hosts {
+			s.Hosts[name] = addr
+		}
+		if s.Resolve == nil && len(env.Resolve) > 0 {
+			s.Resolve = make(map[string]string)
+		}
+		for from, to := range env.Resolve {
+			s.Resolve[from] = to
+		}
+		s.header = env.Header
+		if env.URL != "" && !srvaddrSet {
+			srvaddr = env.URL
+		}
+	}
+
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envVarPrefix) {
+			continue
+		}
+		kv = kv[len(envVarPrefix):]
+		if idx := strings.Index(kv, "="); idx > 0 {
+			s.Param[kv[:idx]] = kv[idx+1:]
+		}
+	}
+
+	for key, val := range vars {
+		s.Param[key] = val
+	}
+	return nil
+}
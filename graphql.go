@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// TaskGraphQL graphql task type
+const TaskGraphQL = "graphql"
+
+// jsonRefRegexp a variable of only [PARAM:json], sent as the json value of the param.
+var jsonRefRegexp = regexp.MustCompile(`^\[([A-Za-z_][A-Za-z0-9_]*):json\]$`)
+
+// graphqlError a response with a non-empty errors array.
+type graphqlError struct {
+	errors []interface{}
+}
+
+func (e *graphqlError) Error() string {
+	msg := ""
+	if first, ok := e.errors[0].(map[string]interface{}); ok {
+		msg, _ = first["message"].(string)
+	}
+	return fmt.Sprintf("graphql %d errors: %s", len(e.errors), msg)
+}
+
+// doGraphQL post the query with the templated variables as json. set_param read the response like data.user.id.
+func (u *User) doGraphQL(task *Task, reqURL string, headerList map[string]string, tr *TraceEntry) (*reqResult, error) {
+	payload := map[string]interface{}{"query": task.Query}
+	if task.Operation != "" {
+		payload["operationName"] = task.Operation
+	}
+	if task.Variables != nil {
+		variables, err := u.replaceVariables(task.Variables)
+		if err != nil {
+			return nil, fmt.Errorf("graphql variables %s", err)
+		}
+		payload["variables"] = variables
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("graphql variables %s", err)
+	}
+
+	// servers answer errors with 200 or an error status, so errors is checked before the status.
+	res, err := sendRequest(u.client, reqURL, "POST", headerList, data, "application/json", task.Compress, string(data), tr)
+	if res != nil {
+		if errs, ok := res.parsed["errors"].([]interface{}); ok && len(errs) > 0 {
+			return res, &graphqlError{errors: errs}
+		}
+	}
+	return res, err
+}
+
+// replaceVariables return a copy of the variables with the params of the strings replaced.
+// a string of only "[PARAM:json]" become the json value of the param, like 3 or true. [PARAM] stay a string.
+func (u *User) replaceVariables(val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case string:
+		if m := jsonRefRegexp.FindStringSubmatch(v); m != nil {
+			param, ok := u.param[m[1]]
+			if !ok {
+				return v, nil
+			}
+			var typed interface{}
+			if err := json.Unmarshal([]byte(param), &typed); err != nil {
+				return nil, fmt.Errorf("%s %q is not json", v, param)
+			}
+			return typed, nil
+		}
+		return u.replaceTemplate(v), nil
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			val, err := u.replaceVariables(item)
+			if err != nil {
+				return nil, err
+			}
+			result[k] = val
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			val, err := u.replaceVariables(item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = val
+		}
+		return result, nil
+	}
+	return val, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGraphQLTask(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Query         string                 `json:"query"`
+			OperationName string                 `json:"operationName"`
+			Variables     map[string]interface{} `json:"variables"`
+		}
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		input, _ := req.Variables["input"].(map[string]interface{})
+		// [NAME] and [ID] stay strings even when they look like numbers, [PARAM:json] is the json value.
+		if req.OperationName != "Login" || input["name"] != "123" || input["level"] != "lv 3" || input["rank"] != float64(3) ||
+			req.Variables["ids"].([]interface{})[0] != "1" || req.Variables["admin"] != true {
+			w.Write([]byte(`{"data":null,"errors":[{"message":"bad login"}]}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"login":{"token":"tok"}}}`))
+	}))
+	defer srv.Close()
+
+	u := &User{param: map[string]string{"NAME": "123", "ID": "1", "LEVEL": "3", "ADMIN": "true"}, client: newHTTPClient()}
+	task := &Task{
+		Type:      TaskGraphQL,
+		Query:     "mutation Login($input: LoginInput!, $ids: [ID!]) { login(input: $input) { token } }",
+		Operation: "Login",
+		Variables: map[string]interface{}{"input": map[string]interface{}{"name": "[NAME]", "age": 3, "level": "lv [LEVEL]", "rank": "[LEVEL:json]"}, "ids": []interface{}{"[ID]"}, "admin": "[ADMIN:json]"},
+	}
+	res, err := u.doTask(task, srv.URL, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.setParam(map[string]string{"[TOKEN]": "data.login.token"}, res.parsed, srv.URL)
+	if u.param["TOKEN"] != "tok" {
+		t.Error("token not set", u.param)
+	}
+	if task.Variables["input"].(map[string]interface{})["name"] != "[NAME]" {
+		t.Error("task variables changed")
+	}
+
+	u.param["NAME"] = "other"
+	if _, err := u.doTask(task, srv.URL, nil, nil); errCategory(err) != ErrGraphQL {
+		t.Error("errors not categorized", err)
+	}
+
+	u.param["ADMIN"] = "yes"
+	if _, err := u.doTask(task, srv.URL, nil, nil); err == nil || err.Error() != `graphql variables [ADMIN:json] "yes" is not json` {
+		t.Error("invalid json not rejected", err)
+	}
+}
+
+func TestGraphQLErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errors":[{"message":"syntax error"}]}`))
+	}))
+	defer srv.Close()
+
+	u := &User{param: map[string]string{}, client: newHTTPClient()}
+	_, err := u.doTask(&Task{Type: TaskGraphQL, Query: "{ me"}, srv.URL, nil, nil)
+	if errCategory(err) != ErrGraphQL || err.Error() != "graphql 1 errors: syntax error" {
+		t.Error("errors of a 400 not categorized", err)
+	}
+}
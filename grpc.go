@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// TaskGRPC grpc task type
+const TaskGRPC = "grpc"
+
+var (
+	grpcMu sync.Mutex
+	// grpcConns shared connection of a target.
+	grpcConns map[string]*grpc.ClientConn
+	// grpcFiles descriptors of "target/service" from server reflection, or of "" from descriptor_set.
+	grpcFiles map[string]*protoregistry.Files
+)
+
+// initGRPC load the senario descriptor_set. without it the descriptors come from server reflection.
+func initGRPC(s *Scenario) error {
+	grpcMu.Lock()
+	defer grpcMu.Unlock()
+
+	for _, conn := range grpcConns {
+		conn.Close()
+	}
+	grpcConns = make(map[string]*grpc.ClientConn)
+	grpcFiles = make(map[string]*protoregistry.Files)
+	if s == nil || s.DescriptorSet == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.DescriptorSet)
+	if err != nil {
+		return err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return fmt.Errorf("descriptor_set %s: %s", s.DescriptorSet, err)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return fmt.Errorf("descriptor_set %s: %s", s.DescriptorSet, err)
+	}
+	grpcFiles[""] = files
+	return nil
+}
+
+// grpcConn return the connection of target for the user. with -conn-mode user every user has its own
+// connections from its -bind-mode user ip, closed by User.Close, else they are shared by all users.
+func (u *User) grpcConn(target string) (*grpc.ClientConn, error) {
+	if connMode == ConnPerUser {
+		if conn, ok := u.grpcConns[target]; ok {
+			return conn, nil
+		}
+		conn, err := newGRPCConn(target, u.local)
+		if err != nil {
+			return nil, err
+		}
+		if u.grpcConns == nil {
+			u.grpcConns = make(map[string]*grpc.ClientConn)
+		}
+		u.grpcConns[target] = conn
+		return conn, nil
+	}
+
+	grpcMu.Lock()
+	defer grpcMu.Unlock()
+	if conn, ok := grpcConns[target]; ok {
+		return conn, nil
+	}
+	conn, err := newGRPCConn(target, nil)
+	if err != nil {
+		return nil, err
+	}
+	grpcConns[target] = conn
+	return conn, nil
+}
+
+// newGRPCConn connect target, "http://host:port" plaintext or "https://host:port" tls, from local.
+func newGRPCConn(target string, local net.IP) (*grpc.ClientConn, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := insecure.NewCredentials()
+	if u.Scheme == "https" {
+		cfg := tlsConfig
+		if c, ok := hostTLSConfig[u.Host]; ok {
+			cfg = c
+		}
+		creds = credentials.NewTLS(cfg.Clone())
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), map[string]string{"http": "80", "https": "443"}[u.Scheme])
+	}
+
+	return grpc.NewClient("passthrough:///"+addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialContext(ctx, "tcp", addr, local)
+		}),
+		grpc.WithStatsHandler(grpcWireHandler{}),
+	)
+}
+
+// grpcMethod find the descriptor of "package.Service/Method".
+func grpcMethod(conn *grpc.ClientConn, target, call string) (protoreflect.MethodDescriptor, error) {
+	i := strings.LastIndex(call, "/")
+	if i < 0 {
+		return nil, fmt.Errorf("call %q want package.Service/Method", call)
+	}
+	service, method := call[:i], call[i+1:]
+
+	// the descriptor_set, or the reflection of the service on the target.
+	key := target + "/" + service
+	grpcMu.Lock()
+	files, ok := grpcFiles[""]
+	if !ok {
+		files, ok = grpcFiles[key]
+	}
+	grpcMu.Unlock()
+	if !ok {
+		var err error
+		if files, err = reflectFiles(conn, service); err != nil {
+			return nil, err
+		}
+		grpcMu.Lock()
+		grpcFiles[key] = files
+		grpcMu.Unlock()
+	}
+
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service %q: %s", service, err)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("service %q has no method %q", service, method)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("not support streaming method %q", call)
+	}
+	return md, nil
+}
+
+// reflectFiles get the file of symbol and its dependencies by server reflection.
+func reflectFiles(conn *grpc.ClientConn, symbol string) (*protoregistry.Files, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTaskTimeout)
+	defer cancel()
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("server reflection %s", err)
+	}
+	defer stream.CloseSend()
+
+	fdps := make(map[string]*descriptorpb.FileDescriptorProto)
+	request := func(req *rpb.ServerReflectionRequest) error {
+		if err := stream.Send(req); err != nil {
+			return fmt.Errorf("server reflection %s", err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return fmt.Errorf("server reflection %s", err)
+		}
+		if e := resp.GetErrorResponse(); e != nil {
+			return fmt.Errorf("server reflection %s", e.GetErrorMessage())
+		}
+		for _, data := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fdp := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(data, fdp); err != nil {
+				return fmt.Errorf("server reflection %s", err)
+			}
+			fdps[fdp.GetName()] = fdp
+		}
+		return nil
+	}
+
+	if err := request(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+	}); err != nil {
+		return nil, err
+	}
+	for missing := true; missing; {
+		missing = false
+		for _, fdp := range fdps {
+			for _, dep := range fdp.GetDependency() {
+				if _, ok := fdps[dep]; ok {
+					continue
+				}
+				missing = true
+				if fd, err := protoregistry.GlobalFiles.FindFileByPath(dep); err == nil {
+					fdps[dep] = protodesc.ToFileDescriptorProto(fd)
+				} else if err := request(&rpb.ServerReflectionRequest{
+					MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+				}); err != nil {
+					return nil, err
+				}
+				if _, ok := fdps[dep]; !ok {
+					return nil, fmt.Errorf("server reflection no file %q", dep)
+				}
+			}
+		}
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fdp := range fdps {
+		set.File = append(set.File, fdp)
+	}
+	return protodesc.NewFiles(set)
+}
+
+// doGRPC call the unary method of the task with the json message. the response is parsed for set_param.
+func (u *User) doGRPC(task *Task, reqURL string, headerList map[string]string, tr *TraceEntry) (*reqResult, error) {
+	msg := u.replaceTemplate(task.Message)
+	if msg == "" {
+		msg = "{}"
+	}
+	if tr != nil {
+		tr.Start = time.Now()
+		tr.Method = "GRPC"
+		tr.URL = reqURL + "/" + task.Call
+		tr.Proto = "grpc"
+		tr.ReqBody = msg
+	}
+
+	conn, err := u.grpcConn(reqURL)
+	if err != nil {
+		return nil, fmt.Errorf("grpc connect %s", err)
+	}
+	md, err := grpcMethod(conn, reqURL, task.Call)
+	if err != nil {
+		return nil, err
+	}
+	req := dynamicpb.NewMessage(md.Input())
+	if err := protojson.Unmarshal([]byte(msg), req); err != nil {
+		return nil, fmt.Errorf("grpc message %s", err)
+	}
+	resp := dynamicpb.NewMessage(md.Output())
+
+	pairs := make([]string, 0, len(headerList)*2)
+	for key, val := range headerList {
+		pairs = append(pairs, strings.ToLower(key), val)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), taskTimeout(task))
+	defer cancel()
+	wire := &wireCount{}
+	ctx = context.WithValue(metadata.NewOutgoingContext(ctx, metadata.Pairs(pairs...)), wireCountKey{}, wire)
+
+	start := time.Now()
+	err = conn.Invoke(ctx, "/"+task.Call, req, resp)
+	total := time.Since(start)
+
+	res := &reqResult{
+		duration: total,
+		total:    total,
+		proto:    "grpc",
+		sent:     atomic.LoadInt64(&wire.sent),
+		recv:     atomic.LoadInt64(&wire.recv),
+	}
+	if tr != nil {
+		tr.Duration = total
+	}
+	if err != nil {
+		return res, err
+	}
+
+	res.respSize = proto.Size(resp)
+	data, err := protojson.Marshal(resp)
+	if err != nil {
+		return res, fmt.Errorf("grpc response %s", err)
+	}
+	if tr != nil {
+		tr.RespBody = string(data)
+	}
+	if err := json.Unmarshal(data, &res.parsed); err != nil {
+		return res, fmt.Errorf("grpc response %s", err)
+	}
+	return res, nil
+}
+
+// grpcCode return the status code of a grpc error.
+func grpcCode(err error) (string, bool) {
+	s, ok := status.FromError(err)
+	if !ok {
+		return "", false
+	}
+	return s.Code().String(), true
+}
+
+// grpcWireHandler count the wire bytes of a call into the wireCount of its context.
+type grpcWireHandler struct{}
+
+func (grpcWireHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (grpcWireHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	w, ok := ctx.Value(wireCountKey{}).(*wireCount)
+	if !ok {
+		return
+	}
+	switch s := s.(type) {
+	case *stats.OutPayload:
+		atomic.AddInt64(&w.sent, int64(s.WireLength))
+	case *stats.InPayload:
+		atomic.AddInt64(&w.recv, int64(s.WireLength))
+	case *stats.InHeader:
+		atomic.AddInt64(&w.recv, int64(s.WireLength))
+	case *stats.InTrailer:
+		atomic.AddInt64(&w.recv, int64(s.WireLength))
+	}
+}
+
+func (grpcWireHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (grpcWireHandler) HandleConn(ctx context.Context, s stats.ConnStats) {}
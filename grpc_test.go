@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func startGRPCServer(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	hs.SetServingStatus("game", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	reflection.Register(srv)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return "http://" + lis.Addr().String()
+}
+
+func TestGRPCTask(t *testing.T) {
+	target := startGRPCServer(t)
+	if err := initGRPC(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer initGRPC(nil)
+
+	u := &User{param: map[string]string{"SERVICE": "game"}}
+	task := &Task{Type: TaskGRPC, Call: "grpc.health.v1.Health/Check", Message: `{"service":"[SERVICE]"}`}
+	res, err := u.doTask(task, target, map[string]string{"Authorization": "Bearer tok"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.setParam(map[string]string{"[STATUS]": "status"}, res.parsed, target)
+	if u.param["STATUS"] != "SERVING" || res.proto != "grpc" || res.sent == 0 || res.recv == 0 {
+		t.Errorf("grpc result %+v param %v", res, u.param)
+	}
+
+	u.param["SERVICE"] = "nope"
+	_, err = u.doTask(task, target, nil, nil)
+	if errCategory(err) != "grpc NotFound" {
+		t.Error("status code not categorized", err)
+	}
+}
+
+func TestGRPCDescriptorSet(t *testing.T) {
+	target := startGRPCServer(t)
+
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(healthpb.File_grpc_health_v1_health_proto),
+	}}
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "health.pb")
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := initGRPC(&Scenario{DescriptorSet: file}); err != nil {
+		t.Fatal(err)
+	}
+	defer initGRPC(nil)
+
+	u := &User{param: map[string]string{}}
+	if _, err := u.doTask(&Task{Type: TaskGRPC, Call: "grpc.health.v1.Health/Nope"}, target, nil, nil); err == nil {
+		t.Error("unknown method not rejected")
+	}
+	if _, err := u.doTask(&Task{Type: TaskGRPC, Call: "grpc.health.v1.Health/Check", Message: `{"service":"game"}`}, target, nil, nil); err != nil {
+		t.Error("check err", err)
+	}
+}
+
+// addrListener record the remote address of the accepted connections.
+type addrListener struct {
+	net.Listener
+	mu    sync.Mutex
+	peers []string
+}
+
+func (l *addrListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.peers = append(l.peers, conn.RemoteAddr().(*net.TCPAddr).IP.String())
+		l.mu.Unlock()
+	}
+	return conn, err
+}
+
+func TestGRPCConnPerUser(t *testing.T) {
+	tcpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := &addrListener{Listener: tcpLis}
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	reflection.Register(srv)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	target := "http://" + tcpLis.Addr().String()
+
+	if err := initGRPC(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer initGRPC(nil)
+	connMode = ConnPerUser
+	defer func() { connMode = "" }()
+
+	task := &Task{Type: TaskGRPC, Call: "grpc.health.v1.Health/Check"}
+	var users []*User
+	for _, ip := range []string{"127.0.0.2", "127.0.0.3"} {
+		u := &User{param: map[string]string{}, client: newHTTPClient(), local: net.ParseIP(ip)}
+		for i := 0; i < 2; i++ {
+			if _, err := u.doTask(task, target, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		users = append(users, u)
+	}
+	if users[0].grpcConns[target] == users[1].grpcConns[target] || len(grpcConns) != 0 {
+		t.Error("grpc connection shared by users")
+	}
+	lis.mu.Lock()
+	if strings.Join(lis.peers, ",") != "127.0.0.2,127.0.0.3" {
+		t.Error("grpc connection not from the user ip", lis.peers)
+	}
+	lis.mu.Unlock()
+
+	users[0].Close()
+	if len(users[0].grpcConns) != 0 {
+		t.Error("grpc connection not closed", users[0].grpcConns)
+	}
+	users[1].Close()
+}
@@ -2,44 +2,187 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"time"
 )
 
-var defaultTransport http.Transport
+var defaultTransport http.RoundTripper
 
 func init() {
-	// Customize the Transport to have larger connection pool
-	defaultRoundTripper := http.DefaultTransport
-	defaultTransportPointer, ok := defaultRoundTripper.(*http.Transport)
+	defaultTransport = newBaseTransport()
+}
+
+// newBaseTransport return a copy of http.DefaultTransport with larger connection pool.
+func newBaseTransport() *http.Transport {
+	defaultTransportPointer, ok := http.DefaultTransport.(*http.Transport)
 	if !ok {
 		panic(fmt.Sprintf("defaultRoundTripper not an *http.Transport"))
 	}
-	defaultTransport = *defaultTransportPointer // dereference it to get a copy of the struct that the pointer points to
-	defaultTransport.MaxIdleConns = 1000
-	defaultTransport.MaxIdleConnsPerHost = 1000
+	t := defaultTransportPointer.Clone()
+	t.MaxIdleConns = 1000
+	t.MaxIdleConnsPerHost = 1000
+	return t
+}
+
+// connection mode
+const (
+	ConnShared      = "shared"
+	ConnPerUser     = "user"
+	ConnNoKeepAlive = "nokeepalive"
+)
+
+// InitTransport check the transport settings and build defaultTransport.
+func InitTransport() error {
+	if useHTTP1 && useHTTP2 {
+		return fmt.Errorf("-http1 and -http2 can not be used together")
+	}
+	if latencyMode != LatencyTotal && latencyMode != LatencyTTFB {
+		return fmt.Errorf("not support latency %q", latencyMode)
+	}
+	if err := checkAcceptEncoding(acceptEncoding); err != nil {
+		return err
+	}
+	switch connMode {
+	case ConnShared, ConnPerUser, ConnNoKeepAlive:
+	default:
+		return fmt.Errorf("not support conn mode %q", connMode)
+	}
+	if err := initTLS(senario); err != nil {
+		return err
+	}
+	if err := initProxy(); err != nil {
+		return err
+	}
+	if err := initResolve(senario); err != nil {
+		return err
+	}
+	if err := initBind(); err != nil {
+		return err
+	}
+	if err := initGRPC(senario); err != nil {
+		return err
+	}
+	defaultTransport = newTransport(nil)
+	return nil
+}
+
+// newTransport build a transport from the command line settings. local is the source ip of every connection,
+// nil for rotating -bind per connection.
+func newTransport(local net.IP) http.RoundTripper {
+	dial := newDialFunc(local)
+	if len(hostTLSConfig) == 0 {
+		return newTLSTransport(tlsConfig, dial)
+	}
+	t := &hostTransport{
+		def:   newTLSTransport(tlsConfig, dial),
+		hosts: make(map[string]http.RoundTripper),
+	}
+	for host, cfg := range hostTLSConfig {
+		t.hosts[host] = newTLSTransport(cfg, dial)
+	}
+	return t
+}
+
+func newTLSTransport(cfg *tls.Config, dial dialFunc) http.RoundTripper {
+	t := newBaseTransport()
+	t.TLSClientConfig = cfg
+	t.DialContext = dial
+	t.Proxy = transportProxy()
+	t.MaxConnsPerHost = maxConns
+	t.DisableCompression = true
+	if connMode == ConnNoKeepAlive {
+		t.DisableKeepAlives = true
+	}
+	if useHTTP1 {
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	if useHTTP2 {
+		return newHTTP2Transport(t, h2Streams)
+	}
+	return t
+}
+
+func closeIdle(rt http.RoundTripper) {
+	if ci, ok := rt.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
 }
 
 func newHTTPClient() *http.Client {
 	return &http.Client{
-		Transport: &defaultTransport,
+		Transport: defaultTransport,
 	}
 }
 
-func doRequest(client *http.Client, path string, method string, headerList map[string]string, urlParamList map[string]string, bodyList map[string]string) (map[string]interface{}, time.Duration, int, error) {
-	// fmt.Printf("param path=%s method=%s header=%+v url_param=%+v body=%+v\n", path, method, headerList, urlParamList, bodyList)
-	var buf io.Reader
-	if bodyList != nil {
-		data := url.Values{}
+// newUserClient return the client of a new user. with -conn-mode user every user has its own connections from local.
+func newUserClient(local net.IP) *http.Client {
+	if connMode == ConnPerUser {
+		return &http.Client{
+			Transport: newTransport(local),
+		}
+	}
+	return newHTTPClient()
+}
+
+// latency mode
+const (
+	LatencyTotal = "total"
+	LatencyTTFB  = "ttfb"
+)
+
+// pickLatency return the duration used for request time stats, ttfb unless -latency total.
+func pickLatency(ttfb, total time.Duration) time.Duration {
+	if latencyMode == LatencyTotal {
+		return total
+	}
+	return ttfb
+}
+
+// reqResult ...
+type reqResult struct {
+	parsed   map[string]interface{}
+	duration time.Duration // ttfb or total by -latency
+	total    time.Duration
+	respSize int   // decoded body
+	sent     int64 // bytes written on the connection
+	recv     int64 // bytes read from the connection
+	h2Sent   int64 // request body of h2, whose connection is shared by streams
+	h2Recv   int64 // response body of h2
+	proto    string
+	status   int
+	timing   *reqTiming
+
+	wsMessage time.Duration // latency of a websocket message
+	wsConn    time.Duration // duration of a closed websocket connection
+
+	events     int
+	firstEvent time.Duration
+	eventGap   Phase
+}
+
+func doRequest(client *http.Client, path string, method string, headerList map[string]string, urlParamList map[string]string, bodyList map[string]string, files map[string]*FilePart, compress string, tr *TraceEntry) (*reqResult, error) {
+	var reqBody, contentType string
+	var data []byte
+	if len(files) > 0 {
+		var err error
+		if data, contentType, reqBody, err = buildMultipart(bodyList, files); err != nil {
+			return nil, err
+		}
+	} else if bodyList != nil {
+		form := url.Values{}
 		for key, val := range bodyList {
-			data.Add(key, val)
+			form.Add(key, val)
 		}
-		buf = bytes.NewBufferString(data.Encode())
+		reqBody = form.Encode()
+		data = []byte(reqBody)
 	}
 
 	if urlParamList != nil {
@@ -49,10 +192,25 @@ func doRequest(client *http.Client, path string, method string, headerList map[s
 		}
 		path += "?" + q.Encode()
 	}
+	return sendRequest(client, path, method, headerList, data, contentType, compress, reqBody, tr)
+}
+
+// sendRequest send data as the request body compressed by compress. reqBody is the body text of the trace.
+func sendRequest(client *http.Client, path string, method string, headerList map[string]string, data []byte, contentType string, compress string, reqBody string, tr *TraceEntry) (*reqResult, error) {
+	var buf io.Reader
+	if data != nil {
+		if compress != "" && len(data) > 0 {
+			var err error
+			if data, err = encodeBody(compress, data); err != nil {
+				return nil, err
+			}
+		}
+		buf = bytes.NewReader(data)
+	}
 
 	req, err := http.NewRequest(method, path, buf)
 	if err != nil {
-		return nil, 0, 0, fmt.Errorf("An error occured http new request %s", err)
+		return nil, fmt.Errorf("An error occured http new request %s", err)
 	}
 	if headerList != nil {
 		for key, val := range headerList {
@@ -60,19 +218,38 @@ func doRequest(client *http.Client, path string, method string, headerList map[s
 		}
 	}
 
-	if method == "POST" {
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	} else if method == "POST" {
 		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	}
+	if compress != "" && len(data) > 0 {
+		req.Header.Set("Content-Encoding", compress)
+	}
+	// the transport compression is disabled, so Accept-Encoding is always ours and the body is decoded here.
+	if acceptEncoding != "" && req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
 
 	start := time.Now()
+	timing := &reqTiming{start: start}
+	wire := &wireCount{}
+	req = req.WithContext(withWire(timing.withTrace(req.Context()), wire))
+	if tr != nil {
+		tr.Start = start
+		tr.Method = req.Method
+		tr.URL = req.URL.String()
+		tr.ReqHeader = req.Header
+		tr.ReqBody = reqBody
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, 0, 0, err
+		return nil, err
 	}
-	duration := time.Since(start)
+	ttfb := time.Since(start)
 
 	if resp == nil {
-		return nil, 0, 0, fmt.Errorf("empty response")
+		return nil, fmt.Errorf("empty response")
 	}
 	defer func() {
 		if resp != nil && resp.Body != nil {
@@ -80,25 +257,48 @@ func doRequest(client *http.Client, path string, method string, headerList map[s
 		}
 	}()
 	body, err := ioutil.ReadAll(resp.Body)
+	total := time.Since(start)
+	timing.mu.Lock()
+	timing.bodyDone = start.Add(total)
+	timing.mu.Unlock()
+	bodyRecv := int64(len(body))
+	if err == nil {
+		if body, err = decodeBody(resp.Header.Get("Content-Encoding"), body); err != nil {
+			err = fmt.Errorf("decode %s %s", resp.Header.Get("Content-Encoding"), err)
+		}
+	}
+	if tr != nil {
+		tr.Duration = total
+		tr.Proto = resp.Proto
+		tr.Status = resp.StatusCode
+		tr.RespHeader = resp.Header
+		tr.RespBody = string(body)
+	}
 	if err != nil {
-		return nil, 0, 0, fmt.Errorf("An error occured reading body %s", err)
+		return nil, fmt.Errorf("An error occured reading body %s", err)
 	}
 
-	var parsed map[string]interface{}
-
-	var respSize int
+	// the bytes of an error status are counted too, so the result is returned with the error.
+	res := &reqResult{
+		duration: pickLatency(ttfb, total),
+		total:    total,
+		respSize: len(body),
+		proto:    resp.Proto,
+		status:   resp.StatusCode,
+		timing:   timing,
+	}
+	res.setWire(resp, wire, int64(len(data)), bodyRecv)
 	if resp.StatusCode == http.StatusOK {
-		respSize = len(body) + int(EstimateHTTPHeadersSize(resp.Header))
 		if len(body) > 0 {
-			if err := json.Unmarshal(body, &parsed); err != nil {
-				return nil, 0, 0, fmt.Errorf("json Unmarshal error %s body=%s", err, string(body))
+			if err := json.Unmarshal(body, &res.parsed); err != nil {
+				return res, fmt.Errorf("json Unmarshal error %s body=%s", err, string(body))
 			}
 		}
-	} else if resp.StatusCode == http.StatusMovedPermanently || resp.StatusCode == http.StatusTemporaryRedirect {
-		respSize = int(resp.ContentLength) + int(EstimateHTTPHeadersSize(resp.Header))
-	} else {
+	} else if resp.StatusCode != http.StatusMovedPermanently && resp.StatusCode != http.StatusTemporaryRedirect {
 		// fmt.Println("received status code", resp.StatusCode, "from", resp.Header, "content", string(body), req)
-		return nil, 0, 0, fmt.Errorf("resp status code err=%d body=%s", resp.StatusCode, string(body))
+		// the body of an error status may be json too, like the errors of graphql.
+		json.Unmarshal(body, &res.parsed)
+		return res, fmt.Errorf("resp status code err=%d body=%s", resp.StatusCode, string(body))
 	}
-	return parsed, duration, respSize, nil
+	return res, nil
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// schemeTransport send https by TLS ALPN h2 and http by prior-knowledge h2c.
+// https go through http.Transport, so -proxy, -max-conns, -conn-mode nokeepalive and the tls timing apply as to http/1.1.
+// h2c has no proxy nor connection limit, a request needing them fail.
+type schemeTransport struct {
+	base *http.Transport
+	h2   *http.Transport
+	h2c  *http2.Transport
+}
+
+func (t *schemeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return t.roundTripH2C(req)
+	}
+	resp, err := t.h2.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ProtoMajor != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("-http2: %s answered %s, not h2", req.URL.Host, resp.Proto)
+	}
+	return resp, nil
+}
+
+func (t *schemeTransport) roundTripH2C(req *http.Request) (*http.Response, error) {
+	if t.base.Proxy != nil {
+		if p, err := t.base.Proxy(req); err != nil || p != nil {
+			return nil, fmt.Errorf("-http2: h2c to %s can not go through the http proxy, use https or a socks5 proxy", req.URL.Host)
+		}
+	}
+	if t.base.MaxConnsPerHost > 0 {
+		return nil, fmt.Errorf("-http2: -max-conns is not supported by h2c to %s", req.URL.Host)
+	}
+	if !t.base.DisableKeepAlives {
+		return t.h2c.RoundTrip(req)
+	}
+
+	// a transport of the request, closed with the body.
+	h2c := newH2CTransport(t.base)
+	resp, err := h2c.RoundTrip(req)
+	if err != nil {
+		h2c.CloseIdleConnections()
+		return nil, err
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: h2c.CloseIdleConnections}
+	return resp, nil
+}
+
+func (t *schemeTransport) CloseIdleConnections() {
+	t.h2.CloseIdleConnections()
+	t.h2c.CloseIdleConnections()
+}
+
+func newSchemeTransport(base *http.Transport) http.RoundTripper {
+	h2 := base.Clone()
+	h2.ForceAttemptHTTP2 = true
+	t2, err := http2.ConfigureTransports(h2)
+	if err != nil {
+		panic(err)
+	}
+	t2.StrictMaxConcurrentStreams = true
+	// h2 only, a server without it fail the request instead of falling back to http/1.1.
+	h2.TLSClientConfig.NextProtos = []string{"h2"}
+
+	return &schemeTransport{
+		base: base,
+		h2:   h2,
+		h2c:  newH2CTransport(base),
+	}
+}
+
+func newH2CTransport(base *http.Transport) *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP:                  true,
+		StrictMaxConcurrentStreams: true,
+		DisableCompression:         true,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return base.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// newHTTP2Transport return the http2 transport. maxStreams > 0 limit the concurrent streams per connection.
+func newHTTP2Transport(base *http.Transport, maxStreams int) http.RoundTripper {
+	if maxStreams <= 0 {
+		return newSchemeTransport(base)
+	}
+	return &streamPool{
+		maxStreams: maxStreams,
+		newConn: func() http.RoundTripper {
+			return newSchemeTransport(base)
+		},
+	}
+}
+
+// streamPool spread requests over http2 transports holding a connection per host each,
+// with at most maxStreams requests in flight on a transport.
+type streamPool struct {
+	mu         sync.Mutex
+	maxStreams int
+	newConn    func() http.RoundTripper
+	conns      []*streamConn
+}
+
+type streamConn struct {
+	rt     http.RoundTripper
+	active int
+}
+
+func (p *streamPool) acquire() *streamConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.conns {
+		if c.active < p.maxStreams {
+			c.active++
+			return c
+		}
+	}
+	c := &streamConn{rt: p.newConn(), active: 1}
+	p.conns = append(p.conns, c)
+	return c
+}
+
+func (p *streamPool) release(c *streamConn) {
+	p.mu.Lock()
+	c.active--
+	p.mu.Unlock()
+}
+
+func (p *streamPool) CloseIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.conns {
+		closeIdle(c.rt)
+	}
+}
+
+func (p *streamPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := p.acquire()
+	resp, err := c.rt.RoundTrip(req)
+	if err != nil {
+		p.release(c)
+		return nil, err
+	}
+	// the stream is open until the body is closed.
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: func() { p.release(c) }}
+	return resp, nil
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+var protoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(`{"proto":"` + r.Proto + `"}`))
+})
+
+func setHTTP2(t *testing.T, mode string, conns int) {
+	useHTTP2, connMode, maxConns = true, mode, conns
+	t.Cleanup(func() { useHTTP2, connMode, maxConns = false, "", 0 })
+}
+
+func TestHTTP2TLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(protoHandler)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	setHTTP2(t, ConnNoKeepAlive, 0)
+	client := &http.Client{Transport: newTLSTransport(&tls.Config{InsecureSkipVerify: true}, newDialFunc(nil))}
+	before := atomic.LoadInt64(&connOpened)
+	for i := 0; i < 2; i++ {
+		res, err := doRequest(client, srv.URL, "GET", nil, nil, nil, nil, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, connect, tlsDur, _, _, _ := res.timing.phases()
+		if res.proto != "HTTP/2.0" || res.parsed["proto"] != "HTTP/2.0" || connect <= 0 || tlsDur <= 0 {
+			t.Errorf("h2 result %+v connect %v tls %v", res, connect, tlsDur)
+		}
+	}
+	if n := atomic.LoadInt64(&connOpened) - before; n != 2 {
+		t.Error("nokeepalive reused connection, opened", n)
+	}
+
+	// a server without h2 fail the alpn, or answer http/1.1.
+	h1 := httptest.NewUnstartedServer(protoHandler)
+	h1.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	h1.StartTLS()
+	defer h1.Close()
+	if _, err := doRequest(client, h1.URL, "GET", nil, nil, nil, nil, "", nil); err == nil {
+		t.Error("http/1.1 server not rejected", err)
+	}
+}
+
+func TestHTTP2Proxy(t *testing.T) {
+	srv := httptest.NewUnstartedServer(protoHandler)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	var tunneled string
+	proxySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		tunneled = r.Host
+		dst, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+		go io.Copy(dst, conn)
+		io.Copy(conn, dst)
+		conn.Close()
+		dst.Close()
+	}))
+	defer proxySrv.Close()
+
+	proxyURL = proxySrv.URL
+	defer func() { proxyURL = "" }()
+	if err := initProxy(); err != nil {
+		t.Fatal(err)
+	}
+	setHTTP2(t, "", 0)
+
+	client := &http.Client{Transport: newTLSTransport(&tls.Config{InsecureSkipVerify: true}, newDialFunc(nil))}
+	res, err := doRequest(client, "https://api.example.com/info", "GET", nil, nil, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tunneled != "api.example.com:443" || res.proto != "HTTP/2.0" {
+		t.Error("not proxied by h2", tunneled, res.proto)
+	}
+	if _, err := doRequest(client, "http://api.example.com/info", "GET", nil, nil, nil, nil, "", nil); err == nil || !strings.Contains(err.Error(), "h2c") {
+		t.Error("h2c through http proxy not rejected", err)
+	}
+}
+
+func TestHTTP2Cleartext(t *testing.T) {
+	srv := httptest.NewServer(h2c.NewHandler(protoHandler, &http2.Server{}))
+	defer srv.Close()
+
+	run := func() (int64, error) {
+		client := &http.Client{Transport: newTLSTransport(nil, newDialFunc(nil))}
+		before := atomic.LoadInt64(&connOpened)
+		for i := 0; i < 2; i++ {
+			res, err := doRequest(client, srv.URL, "GET", nil, nil, nil, nil, "", nil)
+			if err != nil {
+				return 0, err
+			}
+			if res.parsed["proto"] != "HTTP/2.0" {
+				t.Error("not h2c", res.parsed)
+			}
+		}
+		return atomic.LoadInt64(&connOpened) - before, nil
+	}
+
+	setHTTP2(t, "", 0)
+	if n, err := run(); err != nil || n != 1 {
+		t.Error("keep alive h2c opened", n, err)
+	}
+	setHTTP2(t, ConnNoKeepAlive, 0)
+	if n, err := run(); err != nil || n != 2 {
+		t.Error("nokeepalive h2c opened", n, err)
+	}
+	setHTTP2(t, "", 4)
+	if _, err := run(); err == nil || !strings.Contains(err.Error(), "-max-conns") {
+		t.Error("h2c with -max-conns not rejected", err)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// scenarioLoader loads a scenario file and all of its includes.
+type scenarioLoader struct {
+	stack  []string
+	loaded map[string]*Scenario
+}
+
+func (l *scenarioLoader) load(filePath string) (*Scenario, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range l.stack {
+		if p == absPath {
+			return nil, fmt.Errorf("include cycle %s -> %s", strings.Join(l.stack[i:], " -> "), absPath)
+		}
+	}
+	if s, ok := l.loaded[absPath]; ok {
+		return s, nil
+	}
+
+	s, err := readScenario(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	l.stack = append(l.stack, absPath)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
+	s.includes = make(map[string]*Scenario)
+	aliases := make([]string, 0, len(s.Include))
+	for alias := range s.Include {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	// params and hosts of the including file win. includes can not disagree on the rest.
+	ownParam := make(map[string]bool)
+	for key := range s.Param {
+		ownParam[key] = true
+	}
+	ownHosts := make(map[string]bool)
+	for name := range s.Hosts {
+		ownHosts[name] = true
+	}
+	paramFrom := make(map[string]string)
+	hostFrom := make(map[string]string)
+	for _, alias := range aliases {
+		incPath := s.Include[alias]
+		if !filepath.IsAbs(incPath) {
+			incPath = filepath.Join(filepath.Dir(filePath), incPath)
+		}
+		inc, err := l.load(incPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include %q: %s", filePath, alias, err)
+		}
+		s.includes[alias] = inc
+
+		if s.Param == nil && len(inc.Param) > 0 {
+			s.Param = make(map[string]string)
+		}
+		for key, val := range inc.Param {
+			if ownParam[key] {
+				continue
+			}
+			if from, ok := paramFrom[key]; ok && s.Param[key] != val {
+				return nil, fmt.Errorf("%s: param %s differ in include %q and %q, set it in this file", filePath, key, from, alias)
+			}
+			s.Param[key] = val
+			paramFrom[key] = alias
+		}
+		if s.Hosts == nil && len(inc.Hosts) > 0 {
+			s.Hosts = make(map[string]string)
+		}
+		for name, addr := range inc.Hosts {
+			if ownHosts[name] {
+				continue
+			}
+			if from, ok := hostFrom[name]; ok && s.Hosts[name] != addr {
+				return nil, fmt.Errorf("%s: host %s differ in include %q and %q, set it in this file", filePath, name, from, alias)
+			}
+			s.Hosts[name] = addr
+			hostFrom[name] = alias
+		}
+	}
+
+	l.loaded[absPath] = s
+	return s, nil
+}
+
+// expandAll replace every "use" task in pre, run and pre_step with the tasks of the fragment.
+func (s *Scenario) expandAll() error {
+	var err error
+	if s.Pre, err = s.expand("pre", s.Pre, nil); err != nil {
+		return err
+	}
+	if s.Run, err = s.expand("run", s.Run, nil); err != nil {
+		return err
+	}
+	if s.PreStep, err = s.expand("pre_step", s.PreStep, nil); err != nil {
+		return err
+	}
+	if s.Auth != nil {
+		if s.Auth.Tasks, err = s.expand("auth.tasks", s.Auth.Tasks, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Scenario) expand(section string, tasks []*Task, stack []string) ([]*Task, error) {
+	var result []*Task
+	for i, task := range tasks {
+		if task.Use == "" {
+			if task.origin == "" {
+				task.origin = fmt.Sprintf("%s: %s[%d]", s.file, section, i)
+			}
+			result = append(result, task)
+			continue
+		}
+
+		owner, name, err := s.lookupFragment(task.Use)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s[%d]: %s", s.file, section, i, err)
+		}
+
+		key := owner.file + "#" + name
+		for _, k := range stack {
+			if k == key {
+				return nil, fmt.Errorf("%s: %s[%d]: fragment cycle %s -> %s", s.file, section, i, strings.Join(stack, " -> "), key)
+			}
+		}
+
+		fragTasks, err := owner.expand("fragments."+name, owner.Fragments[name], append(stack, key))
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range fragTasks {
+			result = append(result, t.override(task.With))
+		}
+	}
+	return result, nil
+}
+
+// lookupFragment resolve "alias/.../name" through the include tree.
+func (s *Scenario) lookupFragment(use string) (*Scenario, string, error) {
+	owner := s
+	path := strings.Split(use, "/")
+	for _, alias := range path[:len(path)-1] {
+		inc, ok := owner.includes[alias]
+		if !ok {
+			return nil, "", fmt.Errorf("unknown include %q in use %q", alias, use)
+		}
+		owner = inc
+	}
+
+	name := path[len(path)-1]
+	if _, ok := owner.Fragments[name]; !ok {
+		return nil, "", fmt.Errorf("unknown fragment %q in %s", name, owner.file)
+	}
+	return owner, name, nil
+}
+
+// override return a copy of the task with [KEY] of with replaced by its value.
+func (t *Task) override(with map[string]string) *Task {
+	if len(with) == 0 {
+		return t
+	}
+	replace := func(str string) string {
+		for key, val := range with {
+			str = strings.Replace(str, "["+key+"]", val, -1)
+		}
+		return str
+	}
+	replaceMap := func(param map[string]string) map[string]string {
+		if param == nil {
+			return nil
+		}
+		result := make(map[string]string)
+		for k, v := range param {
+			result[k] = replace(v)
+		}
+		return result
+	}
+
+	newTask := *t
+	newTask.URL = replace(t.URL)
+	newTask.WaitSec = replace(t.WaitSec)
+	newTask.URLParam = replaceMap(t.URLParam)
+	newTask.Body = replaceMap(t.Body)
+	newTask.Message = replace(t.Message)
+	newTask.Match = replaceMap(t.Match)
+	newTask.Call = replace(t.Call)
+	newTask.Query = replace(t.Query)
+	if t.Variables != nil {
+		newTask.Variables = replaceValue(t.Variables, with, replace).(map[string]interface{})
+	}
+	if t.Files != nil {
+		newTask.Files = make(map[string]*FilePart)
+		for k, p := range t.Files {
+			newPart := *p
+			newPart.Name = replace(p.Name)
+			newTask.Files[k] = &newPart
+		}
+	}
+	return &newTask
+}
+
+// replaceValue return a copy of the graphql variables with replace applied to its strings.
+// a [KEY:json] of with become the json value of with, or its text when it is not json.
+func replaceValue(val interface{}, with map[string]string, replace func(string) string) interface{} {
+	switch v := val.(type) {
+	case string:
+		if m := jsonRefRegexp.FindStringSubmatch(v); m != nil {
+			if str, ok := with[m[1]]; ok {
+				var typed interface{}
+				if err := json.Unmarshal([]byte(str), &typed); err != nil {
+					return str
+				}
+				return typed
+			}
+		}
+		return replace(v)
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[k] = replaceValue(item, with, replace)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = replaceValue(item, with, replace)
+		}
+		return result
+	}
+	return val
+}
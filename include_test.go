@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeScenario(t *testing.T, dir, name, data string) string {
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeScenario(t, dir, "auth.json", `{
+		"param": {"USER_ID": "guest", "PASSWORD": "1234"},
+		"fragments": {
+			"login": [
+				{"step": "login", "url": "/login/[USER_ID]", "method": "POST", "body": {"pw": "[PASSWORD]"}}
+			]
+		}
+	}`)
+	mainFile := writeScenario(t, dir, "main.json", `{
+		"include": {"auth": "auth.json"},
+		"param": {"USER_ID": "tester"},
+		"pre": [{"use": "auth/login", "with": {"PASSWORD": "abcd"}}],
+		"run": [{"step": "info", "url": "/info"}]
+	}`)
+
+	s, err := LoadConfig(mainFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Param["USER_ID"] != "tester" || s.Param["PASSWORD"] != "1234" {
+		t.Error("param merge err", s.Param)
+	}
+	if len(s.Pre) != 1 || s.Pre[0].URL != "/login/[USER_ID]" || s.Pre[0].Body["pw"] != "abcd" {
+		t.Errorf("expand err %+v", s.Pre[0])
+	}
+	if len(s.Run) != 1 || s.Run[0].Step != "info" {
+		t.Error("run err", s.Run)
+	}
+}
+
+func TestLoadConfigIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeScenario(t, dir, "a.json", `{"include": {"b": "b.json"}}`)
+	writeScenario(t, dir, "b.json", `{"include": {"a": "a.json"}}`)
+
+	_, err := LoadConfig(filepath.Join(dir, "a.json"))
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Error("cycle not detected", err)
+	}
+}
+
+func TestLoadConfigUnknownFragment(t *testing.T) {
+	dir := t.TempDir()
+	mainFile := writeScenario(t, dir, "main.json", `{
+		"run": [{"step": "info", "url": "/info"}, {"use": "logout"}]
+	}`)
+
+	_, err := LoadConfig(mainFile)
+	if err == nil || !strings.Contains(err.Error(), "run[1]") {
+		t.Error("location not reported", err)
+	}
+}
+
+func TestLoadConfigIncludeConflict(t *testing.T) {
+	dir := t.TempDir()
+	writeScenario(t, dir, "a.json", `{"param": {"LEVEL": "1", "MODE": "pvp"}}`)
+	writeScenario(t, dir, "b.json", `{"param": {"LEVEL": "2", "MODE": "pvp"}}`)
+	mainFile := writeScenario(t, dir, "main.json", `{"include": {"a": "a.json", "b": "b.json"}, "run": [{"url": "/info"}]}`)
+
+	_, err := LoadConfig(mainFile)
+	if err == nil || !strings.Contains(err.Error(), `param LEVEL differ in include "a" and "b"`) {
+		t.Error("conflict not reported", err)
+	}
+
+	// the including file decide
+	mainFile = writeScenario(t, dir, "main.json", `{"include": {"a": "a.json", "b": "b.json"}, "param": {"LEVEL": "3"}, "run": [{"url": "/info"}]}`)
+	s, err := LoadConfig(mainFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Param["LEVEL"] != "3" || s.Param["MODE"] != "pvp" {
+		t.Error("param merge err", s.Param)
+	}
+}
+
+// loadFragment load a scenario whose run use the fragment with the with values, and check it.
+func loadFragment(t *testing.T, fragment, with string) *Task {
+	dir := t.TempDir()
+	mainFile := writeScenario(t, dir, "main.json", `{
+		"fragments": {"frag": [`+fragment+`]},
+		"run": [{"use": "frag", "with": `+with+`}]
+	}`)
+	s, err := LoadConfig(mainFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := s.Validate(); len(errs) > 0 {
+		t.Error("with not replaced for -check", errs)
+	}
+	return s.Run[0]
+}
+
+func TestFragmentWithWS(t *testing.T) {
+	send := loadFragment(t, `{"step": "say", "type": "ws_send", "message": "{\"room\":\"[ROOM]\"}"}`, `{"ROOM": "lobby"}`)
+	if send.Message != `{"room":"lobby"}` {
+		t.Error("ws_send message", send.Message)
+	}
+	wait := loadFragment(t, `{"step": "wait", "type": "ws_wait", "match": {"room": "[ROOM]"}}`, `{"ROOM": "lobby"}`)
+	if wait.Match["room"] != "lobby" {
+		t.Error("ws_wait match", wait.Match)
+	}
+}
+
+func TestFragmentWithSocket(t *testing.T) {
+	task := loadFragment(t, `{"step": "join", "type": "socket_send", "message": "JOIN [ROOM]"}`, `{"ROOM": "lobby"}`)
+	if task.Message != "JOIN lobby" {
+		t.Error("socket_send message", task.Message)
+	}
+}
+
+func TestFragmentWithGraphQL(t *testing.T) {
+	task := loadFragment(t, `{"step": "rank", "type": "graphql", "url": "/graphql", "query": "{ rank(season: [SEASON]) }",
+		"variables": {"ids": ["[ID]", 2, "[ID:json]"], "filter": {"room": "[ROOM]"}}}`, `{"SEASON": "3", "ID": "7", "ROOM": "lobby"}`)
+	ids, _ := task.Variables["ids"].([]interface{})
+	filter, _ := task.Variables["filter"].(map[string]interface{})
+	if task.Query != "{ rank(season: 3) }" || len(ids) != 3 || ids[0] != "7" || ids[1] != float64(2) || ids[2] != float64(7) || filter["room"] != "lobby" {
+		t.Errorf("graphql query %q variables %v", task.Query, task.Variables)
+	}
+}
+
+func TestFragmentWithGRPC(t *testing.T) {
+	task := loadFragment(t, `{"step": "get", "type": "grpc", "url": "localhost:50051", "call": "[PKG].Users/Get", "message": "{\"id\":\"[ID]\"}"}`, `{"PKG": "game", "ID": "7"}`)
+	if task.Call != "game.Users/Get" || task.Message != `{"id":"7"}` {
+		t.Errorf("grpc call %q message %q", task.Call, task.Message)
+	}
+}
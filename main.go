@@ -28,6 +28,35 @@ var (
 	ready       bool
 	ramp        int
 	check       bool
+	traceCnt    int
+	harFile     string
+	envName     string
+	vars        varFlags
+	srvaddrSet  bool
+	lbMode      string
+	useHTTP1    bool
+	useHTTP2    bool
+	h2Streams   int
+	connMode    string
+	maxConns    int
+	latencyMode string
+
+	acceptEncoding string
+
+	tlsCAFile     string
+	tlsCertFile   string
+	tlsKeyFile    string
+	tlsInsecure   bool
+	tlsMinVersion string
+	tlsCiphers    string
+	tlsServerName string
+
+	proxyURL     string
+	noProxy      string
+	resolveFlags varFlags
+	dnsServer    string
+	bindAddr     string
+	bindMode     string
 
 	usrIdx          uint32
 	interrupted     int32
@@ -36,6 +65,8 @@ var (
 	senario         *Scenario
 	isZombi         bool
 	throttle        *Throttle
+	tracer          *Tracer
+	balancer        *Balancer
 )
 
 func init() {
@@ -48,7 +79,8 @@ func parseArgument(name string, args []string) error {
 	flagSet.IntVar(&goroutines, "c", runtime.NumCPU()*2, "Number of goroutines to use (concurrent connections)")
 	flagSet.IntVar(&duration, "d", 10, "Duration of test in seconds")
 	flagSet.IntVar(&timeout, "timeout", 30, "timeout of test in seconds")
-	flagSet.StringVar(&srvaddr, "s", "http://127.0.0.1:2142", "server addr default localhost:2142")
+	flagSet.StringVar(&srvaddr, "s", "http://127.0.0.1:2142", "server addr default localhost:2142. addr1,addr2 or @hosts file for several servers")
+	flagSet.StringVar(&lbMode, "lb", LBRoundRobin, "load balance strategy for several servers. roundrobin, random or sticky")
 	flagSet.IntVar(&userCnt, "u", runtime.NumCPU()*2, "pre create user count")
 	flagSet.StringVar(&senarioFile, "f", "", "senario file path")
 	flagSet.StringVar(&redisURL, "r", "", "client mode. redis url")
@@ -56,8 +88,43 @@ func parseArgument(name string, args []string) error {
 	flagSet.BoolVar(&ready, "ready", false, "ready before run")
 	flagSet.IntVar(&ramp, "ramp", 0, "ramp up count")
 	flagSet.BoolVar(&check, "check", false, "check senario")
-
-	return flagSet.Parse(args) // Scan the arguments list
+	flagSet.IntVar(&traceCnt, "trace", 0, "print request/response of first N iterations")
+	flagSet.StringVar(&harFile, "har", "", "write traced request/response as har file")
+	flagSet.BoolVar(&useHTTP1, "http1", false, "force HTTP/1.1")
+	flagSet.BoolVar(&useHTTP2, "http2", false, "force HTTP/2. TLS ALPN for https, prior-knowledge h2c for http")
+	flagSet.IntVar(&h2Streams, "h2-streams", 0, "max concurrent streams per HTTP/2 connection. 0 is the server limit")
+	flagSet.StringVar(&connMode, "conn-mode", ConnShared, "connection mode. shared: one pool for all users, user: connections per user, nokeepalive: new connection per request")
+	flagSet.IntVar(&maxConns, "max-conns", 0, "max connections per host. 0 is no limit")
+	flagSet.StringVar(&acceptEncoding, "accept-encoding", "gzip", "Accept-Encoding header of requests, empty for none. gzip, deflate, br")
+	flagSet.StringVar(&latencyMode, "latency", LatencyTTFB, "request time stats by ttfb: until response header, or total: until body read. Requests/sec is computed from it too")
+	flagSet.StringVar(&tlsCAFile, "ca", "", "tls ca bundle file")
+	flagSet.StringVar(&tlsCertFile, "cert", "", "tls client certificate file")
+	flagSet.StringVar(&tlsKeyFile, "key", "", "tls client key file")
+	flagSet.BoolVar(&tlsInsecure, "insecure", false, "skip tls certificate verification")
+	flagSet.StringVar(&tlsMinVersion, "tls-min", "", "min tls version. 1.0, 1.1, 1.2 or 1.3")
+	flagSet.StringVar(&tlsCiphers, "ciphers", "", "comma separated tls cipher suite names")
+	flagSet.StringVar(&tlsServerName, "sni", "", "tls server name override")
+	flagSet.StringVar(&proxyURL, "proxy", "", "proxy url. http://, https:// or socks5://, user:password@ for auth. loopback targets are proxied too")
+	flagSet.StringVar(&noProxy, "no-proxy", "", "comma separated hosts not to proxy. default is NO_PROXY")
+	resolveFlags = make(varFlags)
+	flagSet.Var(resolveFlags, "resolve", "dial host:port=ip[:port] instead of dns lookup (repeatable)")
+	flagSet.StringVar(&dnsServer, "dns", "", "dns server ip[:port] for target lookup")
+	flagSet.StringVar(&bindAddr, "bind", "", "comma separated source ip or cidr of this host to rotate through, without the network and broadcast address of an ipv4 cidr")
+	flagSet.StringVar(&bindMode, "bind-mode", BindPerConn, "rotate -bind per conn or per user (needs -conn-mode user)")
+	flagSet.StringVar(&envName, "env", "", "senario env name")
+	vars = make(varFlags)
+	flagSet.Var(vars, "var", "override senario param KEY=VALUE (repeatable)")
+
+	if err := flagSet.Parse(args); err != nil { // Scan the arguments list
+		return err
+	}
+	srvaddrSet = false
+	flagSet.Visit(func(f *flag.Flag) {
+		if f.Name == "s" {
+			srvaddrSet = true
+		}
+	})
+	return nil
 }
 
 func main() {
@@ -114,13 +181,42 @@ func ParseTest(cmd string) bool {
 	return false
 }
 
-func Check() {
+// loadSenario load the senario file and apply the command line settings.
+func loadSenario() {
 	var err error
 	senario, err = LoadConfig(senarioFile)
 	if err != nil {
 		fmt.Println("load senario file error", senarioFile, err)
 		panic(err)
 	}
+	if err := senario.ApplyEnv(envName, vars); err != nil {
+		fmt.Println("senario env error", envName, err)
+		panic(err)
+	}
+	if balancer, err = NewBalancer(srvaddr, lbMode); err != nil {
+		fmt.Println("server addr error", srvaddr, err)
+		panic(err)
+	}
+	if err := InitTransport(); err != nil {
+		fmt.Println("transport error", err)
+		panic(err)
+	}
+}
+
+func Check() {
+	loadSenario()
+
+	if errs := senario.Validate(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		fmt.Printf("CHECK Fail! %d problem(s)\n", len(errs))
+		os.Exit(1)
+	}
+
+	if traceCnt > 0 {
+		tracer = NewTracer(traceCnt)
+	}
 
 	statsAggregator = make(chan *RequesterStats)
 	aggStats := make(map[string]*RequesterStats)
@@ -129,7 +225,6 @@ func Check() {
 	if user == nil {
 		panic("user create fail")
 	}
-	user.client = newHTTPClient()
 
 	if senario.IsPre() {
 		if _, err := user.Pre(senario.Pre); err != nil {
@@ -162,6 +257,18 @@ func Check() {
 		result := stats.PrintResult(1)
 		fmt.Printf("%s", result)
 	}
+	fmt.Printf("\nConnections opened:\t%d\n", atomic.LoadInt64(&connOpened))
+
+	writeHAR()
+}
+
+func writeHAR() {
+	if tracer == nil || harFile == "" {
+		return
+	}
+	if err := tracer.WriteHAR(harFile); err != nil {
+		fmt.Println("write har file error", harFile, err)
+	}
 }
 
 // StartTest ...
@@ -169,13 +276,12 @@ func StartTest(wait bool) {
 	if ramp > 0 {
 		throttle = NewThrottle(ramp)
 	}
-
-	var err error
-	senario, err = LoadConfig(senarioFile)
-	if err != nil {
-		fmt.Println("load senario file error", senarioFile, err)
-		panic(err)
+	if traceCnt > 0 {
+		tracer = NewTracer(traceCnt)
 	}
+	atomic.StoreInt64(&connOpened, 0)
+
+	loadSenario()
 	if goroutines > userCnt {
 		userCnt = goroutines * 2
 	}
@@ -241,6 +347,7 @@ func RunTest() {
 			publish("NBA-WRK", result)
 		}
 	}
+	fmt.Printf("\nConnections opened:\t%d\n", atomic.LoadInt64(&connOpened))
 
 	if printCSV != "" {
 		f, err := os.OpenFile(printCSV, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
@@ -255,14 +362,13 @@ func RunTest() {
 		}
 	}
 
+	writeHAR()
 }
 
 // Pre ...
 func Pre(cnt int) chan *User {
 	userPool := make(chan *User, cnt)
 
-	httpClient := newHTTPClient()
-
 	pbar := pb.New(cnt).Prefix("PRE")
 	pbar.Start()
 	for i := 0; i < cnt; i++ {
@@ -275,8 +381,6 @@ func Pre(cnt int) chan *User {
 			break
 		}
 
-		user.client = httpClient
-
 		if _, err := user.Pre(senario.Pre); err != nil {
 			break
 		} else {
@@ -291,8 +395,6 @@ func Pre(cnt int) chan *User {
 
 // Run ...
 func Run(userPool chan *User) {
-	httpClient := newHTTPClient()
-
 	stats := &RequesterStats{Title: "total task", MinRequestTime: time.Minute}
 	start := time.Now()
 	for time.Since(start).Seconds() <= float64(duration) && atomic.LoadInt32(&interrupted) == 0 {
@@ -309,12 +411,15 @@ func Run(userPool chan *User) {
 		} else {
 			user = senario.newUser()
 		}
-		user.client = httpClient
 
 		reqDur, err := user.Run(senario.Run, senario.PreStep)
 		if err == nil {
 			stats.TotDuration += reqDur
 			stats.TotRespSize += int64(user.respSize)
+			stats.TotSentSize += user.sentSize
+			stats.TotRecvSize += user.recvSize
+			stats.TotH2SentSize += user.h2SentSize
+			stats.TotH2RecvSize += user.h2RecvSize
 			stats.MaxRequestTime = MaxDuration(reqDur, stats.MaxRequestTime)
 			stats.MinRequestTime = MinDuration(reqDur, stats.MinRequestTime)
 			stats.NumRequests++
@@ -323,6 +428,8 @@ func Run(userPool chan *User) {
 		}
 		if senario.IsPre() {
 			userPool <- user
+		} else {
+			user.Close()
 		}
 	}
 	statsAggregator <- stats
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"mime/multipart"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FilePart a file field of a multipart task, read from file or random data of size.
+type FilePart struct {
+	File        string `json:"file"`
+	Size        string `json:"size"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+
+	data []byte
+}
+
+var sizeUnits = map[string]int{
+	"":   1,
+	"B":  1,
+	"K":  1 << 10,
+	"KB": 1 << 10,
+	"M":  1 << 20,
+	"MB": 1 << 20,
+}
+
+// parseSize parse "512", "64KB" or "2MB" to bytes.
+func parseSize(str string) (int, error) {
+	s := strings.ToUpper(strings.TrimSpace(str))
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.Atoi(s[:i])
+	unit, ok := sizeUnits[strings.TrimSpace(s[i:])]
+	if err != nil || !ok || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q, want bytes or KB, MB", str)
+	}
+	return n * unit, nil
+}
+
+// check validate the part has one of file and size.
+func (p *FilePart) check() error {
+	if (p.File == "") == (p.Size == "") {
+		return fmt.Errorf("want one of file and size")
+	}
+	if p.Size != "" {
+		if _, err := parseSize(p.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load read the file or generate the random data once.
+func (p *FilePart) load() error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	if p.File != "" {
+		data, err := ioutil.ReadFile(p.File)
+		if err != nil {
+			return err
+		}
+		p.data = data
+		return nil
+	}
+	size, _ := parseSize(p.Size)
+	p.data = make([]byte, size)
+	rand.Read(p.data)
+	return nil
+}
+
+// fileName return the filename of the part, default the base of file.
+func (p *FilePart) fileName() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	if p.File != "" {
+		return filepath.Base(p.File)
+	}
+	return "blob"
+}
+
+// resolveFilePath make the file paths of tasks relative to dir.
+func resolveFilePath(tasks []*Task, dir string) {
+	for _, task := range tasks {
+		for _, p := range task.Files {
+			if p.File != "" && !filepath.IsAbs(p.File) {
+				p.File = filepath.Join(dir, p.File)
+			}
+		}
+	}
+}
+
+// loadFiles load the file parts of pre, run and pre_step tasks.
+func (s *Scenario) loadFiles() error {
+	for _, tasks := range [][]*Task{s.Pre, s.Run, s.PreStep, s.authTasks()} {
+		for _, task := range tasks {
+			for field, p := range task.Files {
+				if p.data != nil {
+					continue
+				}
+				if err := p.load(); err != nil {
+					return fmt.Errorf("%s: files.%s: %s", task.origin, field, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// buildMultipart return the multipart/form-data body of fields and files, its content type,
+// and a text of the body for the trace without the file data.
+func buildMultipart(fields map[string]string, files map[string]*FilePart) ([]byte, string, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	var text []string
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := w.WriteField(k, fields[k]); err != nil {
+			return nil, "", "", err
+		}
+		text = append(text, k+"="+fields[k])
+	}
+
+	keys = keys[:0]
+	for k := range files {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := files[k]
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(k), escapeQuotes(p.fileName())))
+		contentType := p.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h.Set("Content-Type", contentType)
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return nil, "", "", err
+		}
+		if _, err := part.Write(p.data); err != nil {
+			return nil, "", "", err
+		}
+		text = append(text, fmt.Sprintf("%s=@%s (%d bytes)", k, p.fileName(), len(p.data)))
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, "", "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), strings.Join(text, "&"), nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for str, want := range map[string]int{"512": 512, "64KB": 64 << 10, "2m": 2 << 20, "10 B": 10} {
+		if size, err := parseSize(str); err != nil || size != want {
+			t.Errorf("parseSize(%q) = %d, %v want %d", str, size, err, want)
+		}
+	}
+	for _, str := range []string{"", "0", "1GB", "KB"} {
+		if _, err := parseSize(str); err == nil {
+			t.Errorf("parseSize(%q) not rejected", str)
+		}
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		avatar, avatarHeader, _ := r.FormFile("avatar")
+		replay, replayHeader, _ := r.FormFile("replay")
+		if avatar == nil || replay == nil || r.FormValue("nick") != "bust" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := ioutil.ReadAll(avatar)
+		if string(data) != "png" || avatarHeader.Filename != "7.png" || replayHeader.Size != 2048 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	writeScenario(t, dir, "avatar.png", "png")
+	s := &Scenario{Run: []*Task{{
+		URL:    "/upload",
+		Method: "POST",
+		Body:   map[string]string{"nick": "[NICK]"},
+		Files: map[string]*FilePart{
+			"avatar": {File: "avatar.png", Name: "[USER_IDX].png", ContentType: "image/png"},
+			"replay": {Size: "2KB"},
+		},
+		origin: "test: run[0]",
+	}}}
+	resolveFilePath(s.Run, dir)
+	if err := s.loadFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Run[0].Files["avatar"].File != filepath.Join(dir, "avatar.png") {
+		t.Error("file path not resolved", s.Run[0].Files["avatar"].File)
+	}
+
+	u := &User{param: map[string]string{"NICK": "bust", "USER_IDX": "7"}}
+	client := &http.Client{Transport: newTLSTransport(&tls.Config{}, newDialFunc(nil))}
+	task := s.Run[0]
+	if _, err := doRequest(client, srv.URL+task.URL, task.Method, nil, nil, u.parseParam(task.Body), u.parseFiles(task.Files), "", nil); err != nil {
+		t.Error("upload err", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+var (
+	// proxyFunc return the proxy of a request url, nil for NO_PROXY hosts.
+	proxyFunc   func(*url.URL) (*url.URL, error)
+	socksDialer proxy.ContextDialer
+)
+
+// initProxy build the -proxy settings. http(s) proxy is used by the transport, socks5 by dialContext.
+func initProxy() error {
+	proxyFunc, socksDialer = nil, nil
+	if proxyURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("proxy %q: %s", proxyURL, err)
+	}
+
+	exclude := noProxy
+	if exclude == "" {
+		exclude = os.Getenv("NO_PROXY")
+	}
+	if exclude == "" {
+		exclude = os.Getenv("no_proxy")
+	}
+	cfg := &httpproxy.Config{
+		HTTPProxy:  proxyURL,
+		HTTPSProxy: proxyURL,
+		NoProxy:    exclude,
+	}
+	envFunc := cfg.ProxyFunc()
+	// httpproxy never proxy loopback, but -proxy is explicit, so loopback go through it unless listed in no proxy.
+	proxyFunc = func(reqURL *url.URL) (*url.URL, error) {
+		if isLoopback(reqURL.Hostname()) {
+			if noProxyHost(exclude, reqURL.Host) {
+				return nil, nil
+			}
+			return u, nil
+		}
+		return envFunc(reqURL)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+		d, err := proxy.SOCKS5("tcp", u.Host, auth, netDialer)
+		if err != nil {
+			return fmt.Errorf("proxy %q: %s", proxyURL, err)
+		}
+		socksDialer = d.(proxy.ContextDialer)
+	default:
+		return fmt.Errorf("not support proxy scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+// transportProxy return the Proxy of http.Transport. without -proxy the environment is used.
+func transportProxy() func(*http.Request) (*url.URL, error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment
+	}
+	if socksDialer != nil {
+		return nil
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+}
+
+// useSocks true if the connection to addr go through the socks5 proxy.
+func useSocks(addr string) bool {
+	if socksDialer == nil {
+		return false
+	}
+	p, err := proxyFunc(&url.URL{Scheme: "https", Host: addr})
+	return err == nil && p != nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// noProxyHost true if host or host:port is an entry of the comma separated list, or the list is "*".
+func noProxyHost(list, hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "*" || entry == host || entry == hostport {
+			return true
+		}
+	}
+	return false
+}
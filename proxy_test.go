@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestHTTPProxy(t *testing.T) {
+	var proxied string
+	proxySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte(`{}`))
+	}))
+	defer proxySrv.Close()
+
+	proxyURL, noProxy = proxySrv.URL, "skip.example.com"
+	defer func() { proxyURL, noProxy = "", "" }()
+	if err := initProxy(); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: newTLSTransport(nil, newDialFunc(nil))}
+	if _, err := doRequest(client, "http://api.example.com/info", "GET", nil, nil, nil, nil, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if proxied != "http://api.example.com/info" {
+		t.Error("not proxied", proxied)
+	}
+
+	skip, _ := url.Parse("http://skip.example.com/info")
+	if p, _ := proxyFunc(skip); p != nil {
+		t.Error("no proxy host proxied", p)
+	}
+
+	// the default -s is loopback
+	if _, err := doRequest(client, "http://127.0.0.1:2142/info", "GET", nil, nil, nil, nil, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if proxied != "http://127.0.0.1:2142/info" {
+		t.Error("loopback not proxied", proxied)
+	}
+	noProxy = "localhost, 127.0.0.1:2142"
+	if err := initProxy(); err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"localhost:80", "127.0.0.1:2142"} {
+		if p, _ := proxyFunc(&url.URL{Scheme: "http", Host: host}); p != nil {
+			t.Error("no proxy loopback proxied", host)
+		}
+	}
+	if p, _ := proxyFunc(&url.URL{Scheme: "http", Host: "127.0.0.1:8080"}); p == nil {
+		t.Error("loopback not in no proxy not proxied")
+	}
+}
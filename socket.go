@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// socket task type
+const (
+	SocketConnect = "socket_connect"
+	SocketSend    = "socket_send"
+	SocketRead    = "socket_read"
+	SocketClose   = "socket_close"
+)
+
+// payload encoding of socket_send message and socket_read trace
+const (
+	PayloadText   = "text"
+	PayloadHex    = "hex"
+	PayloadBase64 = "base64"
+)
+
+const maxFrameSize = 64 << 10
+
+// sockConn the tcp or udp connection of a user.
+type sockConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	udp    bool
+	url    string
+}
+
+func isSocketTask(task *Task) bool {
+	switch task.Type {
+	case SocketConnect, SocketSend, SocketRead, SocketClose:
+		return true
+	}
+	return false
+}
+
+// encodePayload return the bytes of a message by encoding.
+func encodePayload(encoding, msg string) ([]byte, error) {
+	switch encoding {
+	case "", PayloadText:
+		return []byte(msg), nil
+	case PayloadHex:
+		return hex.DecodeString(strings.Join(strings.Fields(msg), ""))
+	case PayloadBase64:
+		return base64.StdEncoding.DecodeString(msg)
+	}
+	return nil, fmt.Errorf("not support encoding %q", encoding)
+}
+
+// decodePayload return the text of data by encoding for the trace.
+func decodePayload(encoding string, data []byte) string {
+	switch encoding {
+	case PayloadHex:
+		return hex.EncodeToString(data)
+	case PayloadBase64:
+		return base64.StdEncoding.EncodeToString(data)
+	}
+	return string(data)
+}
+
+// doSocket run a socket task on the connection of the user.
+// a failed task close the connection, so the next socket_connect start again.
+func (u *User) doSocket(task *Task, reqURL string, tr *TraceEntry) (*reqResult, error) {
+	start := time.Now()
+	if tr != nil {
+		tr.Start = start
+		tr.Method = strings.ToUpper(task.Type)
+		tr.URL = reqURL
+	}
+
+	wire := &wireCount{}
+	res := &reqResult{}
+	var err error
+	if task.Type == SocketConnect {
+		err = u.sockConnect(task, reqURL, wire)
+	} else if u.sock == nil {
+		err = fmt.Errorf("%s: socket not connected", task.Type)
+	} else {
+		if c, ok := u.sock.conn.(*countConn); ok {
+			c.owner.Store(wire)
+		}
+		switch task.Type {
+		case SocketSend:
+			err = u.sockSend(task, tr)
+		case SocketRead:
+			res.parsed, res.respSize, err = u.sockRead(task, tr)
+		case SocketClose:
+			err = u.sock.conn.Close()
+			u.sock = nil
+		}
+	}
+	if err != nil && u.sock != nil {
+		u.sock.conn.Close()
+		u.sock = nil
+	}
+
+	res.proto = "tcp"
+	if strings.HasPrefix(reqURL, "udp://") {
+		res.proto = "udp"
+	}
+	res.total = time.Since(start)
+	res.duration = res.total
+	res.sent = atomic.LoadInt64(&wire.sent)
+	res.recv = atomic.LoadInt64(&wire.recv)
+	if tr != nil {
+		tr.Duration = res.total
+		tr.Proto = res.proto
+	}
+	return res, err
+}
+
+func (u *User) sockConnect(task *Task, reqURL string, wire *wireCount) error {
+	if u.sock != nil {
+		u.sock.conn.Close()
+		u.sock = nil
+	}
+	parsed, err := url.Parse(reqURL)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "tcp" && parsed.Scheme != "udp" {
+		return fmt.Errorf("socket_connect url %q want tcp:// or udp://", reqURL)
+	}
+
+	ctx, cancel := context.WithTimeout(withWire(context.Background(), wire), taskTimeout(task))
+	defer cancel()
+	conn, err := dialContext(ctx, parsed.Scheme, parsed.Host, u.local)
+	if err != nil {
+		return fmt.Errorf("socket connect %s", err)
+	}
+	u.sock = &sockConn{conn: conn, reader: bufio.NewReader(conn), udp: parsed.Scheme == "udp", url: reqURL}
+	return nil
+}
+
+func (u *User) sockSend(task *Task, tr *TraceEntry) error {
+	msg := u.replaceTemplate(task.Message)
+	if tr != nil {
+		tr.ReqBody = msg
+	}
+	data, err := encodePayload(task.Encoding, msg)
+	if err != nil {
+		return fmt.Errorf("socket_send message %s", err)
+	}
+	if task.LengthPrefix > 0 {
+		data = append(lengthHeader(task.LengthPrefix, len(data)), data...)
+	}
+
+	u.sock.conn.SetWriteDeadline(time.Now().Add(taskTimeout(task)))
+	if _, err := u.sock.conn.Write(data); err != nil {
+		return fmt.Errorf("socket send %s", err)
+	}
+	return nil
+}
+
+// sockRead read a frame, a udp datagram or until the delimiter or by the length prefix,
+// then set the params of fields. a json frame is also parsed for set_param.
+func (u *User) sockRead(task *Task, tr *TraceEntry) (map[string]interface{}, int, error) {
+	for key := range task.Fields {
+		if !isParamKey(key) {
+			return nil, 0, fmt.Errorf("socket_read fields key %q must be [KEY] form", key)
+		}
+	}
+	u.sock.conn.SetReadDeadline(time.Now().Add(taskTimeout(task)))
+	frame, err := u.readFrame(task)
+	if err != nil {
+		return nil, 0, fmt.Errorf("socket read %s", err)
+	}
+	if tr != nil {
+		tr.RespBody = decodePayload(task.Encoding, frame)
+	}
+
+	for key, spec := range task.Fields {
+		val, err := extractField(frame, spec)
+		if err != nil {
+			return nil, len(frame), fmt.Errorf("socket_read field %s: %s", key, err)
+		}
+		u.param[key[1:len(key)-1]] = val
+	}
+	var parsed map[string]interface{}
+	json.Unmarshal(frame, &parsed)
+	return parsed, len(frame), nil
+}
+
+func (u *User) readFrame(task *Task) ([]byte, error) {
+	r := u.sock.reader
+	if u.sock.udp {
+		buf := make([]byte, maxFrameSize)
+		n, err := u.sock.conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		frame := buf[:n]
+		switch {
+		case task.LengthPrefix > 0:
+			if len(frame) < task.LengthPrefix {
+				return nil, fmt.Errorf("datagram %d bytes shorter than the length prefix", len(frame))
+			}
+			size := readLength(frame[:task.LengthPrefix])
+			frame = frame[task.LengthPrefix:]
+			if size > len(frame) {
+				return nil, fmt.Errorf("datagram length prefix %d over %d bytes", size, len(frame))
+			}
+			frame = frame[:size]
+		case task.Delimiter != "":
+			return nil, fmt.Errorf("delimiter is not supported on udp, a datagram is a frame")
+		}
+		return frame, nil
+	}
+
+	switch {
+	case task.LengthPrefix > 0:
+		header := make([]byte, task.LengthPrefix)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		size := readLength(header)
+		if size > maxFrameSize {
+			return nil, fmt.Errorf("frame length %d over %d", size, maxFrameSize)
+		}
+		frame := make([]byte, size)
+		_, err := io.ReadFull(r, frame)
+		return frame, err
+	case task.Delimiter != "":
+		delim := []byte(task.Delimiter)
+		var frame []byte
+		for !bytes.HasSuffix(frame, delim) {
+			if len(frame) > maxFrameSize {
+				return nil, fmt.Errorf("no delimiter in %d bytes", maxFrameSize)
+			}
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			frame = append(frame, b)
+		}
+		return frame[:len(frame)-len(delim)], nil
+	}
+	buf := make([]byte, maxFrameSize)
+	n, err := r.Read(buf)
+	return buf[:n], err
+}
+
+// isParamKey true if key is the [KEY] form of set_param and fields.
+func isParamKey(key string) bool {
+	return len(key) >= 3 && key[0] == '[' && key[len(key)-1] == ']'
+}
+
+// lengthHeader return the big endian length prefix of size bytes.
+func lengthHeader(size, length int) []byte {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint64(header, uint64(length))
+	return header[8-size:]
+}
+
+func readLength(header []byte) int {
+	return int(readUint(header))
+}
+
+func readUint(data []byte) uint64 {
+	var n uint64
+	for _, b := range data {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
+
+// fieldSpec a field of a socket_read frame.
+type fieldSpec struct {
+	kind   string
+	offset int
+	size   int // -1 to the end of the frame
+}
+
+// parseField parse u8@N, u16@N, u32@N, u64@N big endian at offset N, or str@N:L, hex@N:L of L bytes, to the end without L.
+func parseField(spec string) (*fieldSpec, error) {
+	i := strings.Index(spec, "@")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid field %q, want type@offset", spec)
+	}
+	f := &fieldSpec{kind: spec[:i], size: -1}
+	pos := spec[i+1:]
+
+	var lenStr string
+	if j := strings.Index(pos, ":"); j >= 0 {
+		pos, lenStr = pos[:j], pos[j+1:]
+	}
+	var err error
+	if f.offset, err = strconv.Atoi(pos); err != nil || f.offset < 0 {
+		return nil, fmt.Errorf("invalid field %q offset", spec)
+	}
+
+	switch f.kind {
+	case "u8", "u16", "u32", "u64":
+		if lenStr != "" {
+			return nil, fmt.Errorf("invalid field %q, %s has no length", spec, f.kind)
+		}
+		f.size = map[string]int{"u8": 1, "u16": 2, "u32": 4, "u64": 8}[f.kind]
+	case "str", "hex":
+		if lenStr != "" {
+			if f.size, err = strconv.Atoi(lenStr); err != nil || f.size < 0 {
+				return nil, fmt.Errorf("invalid field %q length", spec)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid field %q, not support type %q", spec, f.kind)
+	}
+	return f, nil
+}
+
+// extractField return the field of frame by spec as a param value.
+func extractField(frame []byte, spec string) (string, error) {
+	f, err := parseField(spec)
+	if err != nil {
+		return "", err
+	}
+	size := f.size
+	if size < 0 {
+		size = len(frame) - f.offset
+	}
+	if size < 0 || f.offset+size > len(frame) {
+		return "", fmt.Errorf("field %q out of %d bytes frame", spec, len(frame))
+	}
+
+	data := frame[f.offset : f.offset+size]
+	switch f.kind {
+	case "str":
+		return string(data), nil
+	case "hex":
+		return hex.EncodeToString(data), nil
+	}
+	return strconv.FormatUint(readUint(data), 10), nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestExtractField(t *testing.T) {
+	frame := []byte{0x01, 0x00, 0x07, 'h', 'i'}
+	for spec, want := range map[string]string{"u8@0": "1", "u16@1": "7", "str@3": "hi", "hex@1:2": "0007"} {
+		if val, err := extractField(frame, spec); err != nil || val != want {
+			t.Errorf("extractField(%q) = %q, %v want %q", spec, val, err, want)
+		}
+	}
+	for _, spec := range []string{"u32@3", "str@1:9", "u8", "f32@0", "u8@0:1"} {
+		if _, err := extractField(frame, spec); err == nil {
+			t.Errorf("extractField(%q) not rejected", spec)
+		}
+	}
+}
+
+func TestSocketTasks(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		// join: 2 bytes length + "JOIN nick" -> 2 bytes length + room id u32 + "ok"
+		header := make([]byte, 2)
+		io.ReadFull(r, header)
+		body := make([]byte, readLength(header))
+		io.ReadFull(r, body)
+		if string(body) != "JOIN bust" {
+			return
+		}
+		conn.Write([]byte{0x00, 0x06, 0x00, 0x00, 0x01, 0x02, 'o', 'k'})
+		// chat line -> echo line
+		line, _ := r.ReadString('\n')
+		conn.Write([]byte("echo " + line))
+	}()
+
+	u := &User{param: map[string]string{"NICK": "bust"}}
+	defer u.Close()
+	run := func(task *Task) *reqResult {
+		res, err := u.doTask(task, u.requestURL(task), nil, nil)
+		if err != nil {
+			t.Fatal(task.Type, err)
+		}
+		return res
+	}
+
+	run(&Task{Type: SocketConnect, URL: "tcp://" + lis.Addr().String()})
+	run(&Task{Type: SocketSend, Message: "JOIN [NICK]", LengthPrefix: 2})
+	res := run(&Task{Type: SocketRead, LengthPrefix: 2, Fields: map[string]string{"[ROOM_ID]": "u32@0", "[RESULT]": "str@4"}})
+	if u.param["ROOM_ID"] != "258" || u.param["RESULT"] != "ok" || res.respSize != 6 || res.recv != 8 {
+		t.Errorf("read result %+v param %v", res, u.param)
+	}
+	run(&Task{Type: SocketSend, Message: "68 69 0a", Encoding: PayloadHex})
+	run(&Task{Type: SocketRead, Delimiter: "\n", Fields: map[string]string{"[LINE]": "str@0"}})
+	if u.param["LINE"] != "echo hi" {
+		t.Error("delimiter read err", u.param)
+	}
+	run(&Task{Type: SocketClose})
+	if _, err := u.doTask(&Task{Type: SocketRead}, "", nil, nil); err == nil {
+		t.Error("read after close not failed")
+	}
+}
+
+func TestSocketUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pc.WriteTo(append([]byte("pong:"), buf[:n]...), addr)
+	}()
+
+	u := &User{param: map[string]string{}}
+	defer u.Close()
+	for _, task := range []*Task{
+		{Type: SocketConnect, URL: "udp://" + pc.LocalAddr().String()},
+		{Type: SocketSend, Message: "cGluZw==", Encoding: PayloadBase64},
+		{Type: SocketRead, Fields: map[string]string{"[REPLY]": "str@0"}},
+	} {
+		if _, err := u.doTask(task, u.requestURL(task), nil, nil); err != nil {
+			t.Fatal(task.Type, err)
+		}
+	}
+	if u.param["REPLY"] != "pong:ping" {
+		t.Error("udp reply err", u.param)
+	}
+}
+
+func TestSocketUDPLengthPrefix(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil || n < 2 || readLength(buf[:2]) != n-2 {
+			return
+		}
+		// 2 bytes length + "ok:" + body, with trailing padding outside the length
+		reply := append([]byte("ok:"), buf[2:n]...)
+		pc.WriteTo(append(append(lengthHeader(2, len(reply)), reply...), 0, 0), addr)
+	}()
+
+	u := &User{param: map[string]string{}}
+	defer u.Close()
+	for _, task := range []*Task{
+		{Type: SocketConnect, URL: "udp://" + pc.LocalAddr().String()},
+		{Type: SocketSend, Message: "ping", LengthPrefix: 2},
+		{Type: SocketRead, LengthPrefix: 2, Fields: map[string]string{"[REPLY]": "str@0"}},
+	} {
+		if _, err := u.doTask(task, u.requestURL(task), nil, nil); err != nil {
+			t.Fatal(task.Type, err)
+		}
+	}
+	if u.param["REPLY"] != "ok:ping" {
+		t.Error("udp length prefix reply err", u.param)
+	}
+}
+
+func TestSocketUDPDelimiter(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pc.WriteTo(buf[:n], addr)
+	}()
+
+	u := &User{param: map[string]string{}}
+	defer u.Close()
+	for _, task := range []*Task{
+		{Type: SocketConnect, URL: "udp://" + pc.LocalAddr().String()},
+		{Type: SocketSend, Message: "hi\n"},
+	} {
+		if _, err := u.doTask(task, u.requestURL(task), nil, nil); err != nil {
+			t.Fatal(task.Type, err)
+		}
+	}
+	if _, err := u.doTask(&Task{Type: SocketRead, Delimiter: "\n"}, "", nil, nil); err == nil || !strings.Contains(err.Error(), "delimiter") {
+		t.Error("delimiter on udp not rejected", err)
+	}
+	// a failed task close the socket.
+	connect := &Task{Type: SocketConnect, URL: "udp://" + pc.LocalAddr().String()}
+	if _, err := u.doTask(connect, u.requestURL(connect), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.doTask(&Task{Type: SocketRead, Fields: map[string]string{"ID": "u8@0"}}, "", nil, nil); err == nil || !strings.Contains(err.Error(), "[KEY] form") {
+		t.Error("fields key not rejected", err)
+	}
+}
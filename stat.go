@@ -1,19 +1,43 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 )
 
 // RequesterStats used for colelcting aggregate statistics
 type RequesterStats struct {
 	Title          string
-	TotRespSize    int64
+	TotRespSize    int64 // decoded body
+	TotSentSize    int64 // on the wire
+	TotRecvSize    int64 // on the wire
+	TotH2SentSize  int64 // h2 request body, not in the wire bytes
+	TotH2RecvSize  int64 // h2 response body, not in the wire bytes
 	TotDuration    time.Duration
 	MinRequestTime time.Duration
 	MaxRequestTime time.Duration
 	NumRequests    int
 	NumErrs        int
+	Protos         map[string]int
+	ErrCategories  map[string]int
+
+	DNS      Phase
+	Connect  Phase
+	TLS      Phase
+	Wait     Phase
+	TTFB     Phase
+	Transfer Phase
+	Total    Phase
+
+	WSMessage Phase
+	WSConn    Phase
+
+	Events     int
+	FirstEvent Phase
+	EventGap   Phase
 }
 
 // MaxDuration ...
@@ -32,17 +56,109 @@ func MinDuration(d1 time.Duration, d2 time.Duration) time.Duration {
 	return d2
 }
 
-func (rs *RequesterStats) Err() {
+// error category
+const (
+	ErrPortExhausted = "port exhausted"
+	ErrGraphQL       = "graphql errors"
+)
+
+// errCategory return the category of err reported apart from the other errors, "" for none.
+func errCategory(err error) string {
+	if isPortExhausted(err) {
+		return ErrPortExhausted
+	}
+	var gqlErr *graphqlError
+	if errors.As(err, &gqlErr) {
+		return ErrGraphQL
+	}
+	if code, ok := grpcCode(err); ok {
+		return "grpc " + code
+	}
+	return ""
+}
+
+// Err ...
+func (rs *RequesterStats) Err(err error) {
 	rs.NumErrs++
+	if category := errCategory(err); category != "" {
+		if rs.ErrCategories == nil {
+			rs.ErrCategories = make(map[string]int)
+		}
+		rs.ErrCategories[category]++
+	}
+}
+
+// Wire add the bytes sent and received by a request, also for the failed ones.
+func (rs *RequesterStats) Wire(res *reqResult) {
+	rs.TotSentSize += res.sent
+	rs.TotRecvSize += res.recv
+	rs.TotH2SentSize += res.h2Sent
+	rs.TotH2RecvSize += res.h2Recv
 }
 
 // Calc
-func (rs *RequesterStats) Calc(due time.Duration, respSize int) {
+func (rs *RequesterStats) Calc(res *reqResult) {
 	rs.NumRequests++
-	rs.TotRespSize += int64(respSize)
-	rs.TotDuration += due
-	rs.MaxRequestTime = MaxDuration(rs.MaxRequestTime, due)
-	rs.MinRequestTime = MinDuration(rs.MinRequestTime, due)
+	rs.TotRespSize += int64(res.respSize)
+	rs.Wire(res)
+	rs.TotDuration += res.duration
+	rs.MaxRequestTime = MaxDuration(rs.MaxRequestTime, res.duration)
+	rs.MinRequestTime = MinDuration(rs.MinRequestTime, res.duration)
+	if res.proto != "" {
+		if rs.Protos == nil {
+			rs.Protos = make(map[string]int)
+		}
+		rs.Protos[res.proto]++
+	}
+	if res.timing != nil {
+		dns, connect, tls, wait, ttfb, transfer := res.timing.phases()
+		// dns, connect and tls only happen on a new connection.
+		if dns > 0 {
+			rs.DNS.add(dns)
+		}
+		if connect > 0 {
+			rs.Connect.add(connect)
+		}
+		if tls > 0 {
+			rs.TLS.add(tls)
+		}
+		rs.Wait.add(wait)
+		rs.TTFB.add(ttfb)
+		rs.Transfer.add(transfer)
+	}
+	if res.total > 0 {
+		rs.Total.add(res.total)
+	}
+	if res.wsMessage > 0 {
+		rs.WSMessage.add(res.wsMessage)
+	}
+	if res.wsConn > 0 {
+		rs.WSConn.add(res.wsConn)
+	}
+	if res.events > 0 {
+		rs.Events += res.events
+		rs.FirstEvent.add(res.firstEvent)
+		rs.EventGap.merge(res.eventGap)
+	}
+}
+
+// clone return a copy that does not share the maps, as the aggregator Add into the first stats of a title.
+func (rs *RequesterStats) clone() *RequesterStats {
+	c := *rs
+	c.Protos = copyCounts(rs.Protos)
+	c.ErrCategories = copyCounts(rs.ErrCategories)
+	return &c
+}
+
+func copyCounts(m map[string]int) map[string]int {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]int, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
 }
 
 // Add ...
@@ -50,9 +166,54 @@ func (rs *RequesterStats) Add(new *RequesterStats) {
 	rs.NumErrs += new.NumErrs
 	rs.NumRequests += new.NumRequests
 	rs.TotRespSize += new.TotRespSize
+	rs.TotSentSize += new.TotSentSize
+	rs.TotRecvSize += new.TotRecvSize
+	rs.TotH2SentSize += new.TotH2SentSize
+	rs.TotH2RecvSize += new.TotH2RecvSize
 	rs.TotDuration += new.TotDuration
 	rs.MaxRequestTime = MaxDuration(rs.MaxRequestTime, new.MaxRequestTime)
 	rs.MinRequestTime = MinDuration(rs.MinRequestTime, new.MinRequestTime)
+	for proto, cnt := range new.Protos {
+		if rs.Protos == nil {
+			rs.Protos = make(map[string]int)
+		}
+		rs.Protos[proto] += cnt
+	}
+	for category, cnt := range new.ErrCategories {
+		if rs.ErrCategories == nil {
+			rs.ErrCategories = make(map[string]int)
+		}
+		rs.ErrCategories[category] += cnt
+	}
+	rs.DNS.merge(new.DNS)
+	rs.Connect.merge(new.Connect)
+	rs.TLS.merge(new.TLS)
+	rs.Wait.merge(new.Wait)
+	rs.TTFB.merge(new.TTFB)
+	rs.Transfer.merge(new.Transfer)
+	rs.Total.merge(new.Total)
+	rs.WSMessage.merge(new.WSMessage)
+	rs.WSConn.merge(new.WSConn)
+	rs.Events += new.Events
+	rs.FirstEvent.merge(new.FirstEvent)
+	rs.EventGap.merge(new.EventGap)
+}
+
+// protoString "HTTP/2.0:10 HTTP/1.1:2"
+func (rs *RequesterStats) protoString() string {
+	return countString(rs.Protos)
+}
+
+func countString(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		keys[i] = fmt.Sprintf("%s:%d", key, counts[key])
+	}
+	return strings.Join(keys, " ")
 }
 
 // PrintResult ...
@@ -60,26 +221,63 @@ func (rs *RequesterStats) PrintResult(responders int) string {
 	result := fmt.Sprintf("\nTitle:\t\t\t%v\n", rs.Title)
 
 	if rs.NumRequests == 0 {
-		return result + fmt.Sprintf("Number of Errors:\t%v\n", rs.NumErrs)
+		return result + rs.errString()
 	}
 	avgThreadDur := rs.TotDuration / time.Duration(responders) //need to average the aggregated duration
 
 	reqRate := float64(rs.NumRequests) / avgThreadDur.Seconds()
 	avgReqTime := rs.TotDuration / time.Duration(rs.NumRequests)
-	bytesRate := float64(rs.TotRespSize) / avgThreadDur.Seconds()
+	downRate := float64(rs.TotRecvSize) / avgThreadDur.Seconds()
+	upRate := float64(rs.TotSentSize) / avgThreadDur.Seconds()
 
-	result += fmt.Sprintf("%v requests in %v, %v read (%v)\n", rs.NumRequests, avgThreadDur, ByteSize{float64(rs.TotRespSize)}, ByteSize{float64(int(rs.TotRespSize) / rs.NumRequests)})
-	result += fmt.Sprintf("Requests/sec:\t\t%.2f\nTransfer/sec:\t\t%v\nAvg Req Time:\t\t%v\n", reqRate, ByteSize{bytesRate}, avgReqTime)
+	result += fmt.Sprintf("%v requests in %v, %v read (%v), %v written\n", rs.NumRequests, avgThreadDur, ByteSize{float64(rs.TotRecvSize)}, ByteSize{float64(int(rs.TotRecvSize) / rs.NumRequests)}, ByteSize{float64(rs.TotSentSize)})
+	result += fmt.Sprintf("Decoded Body:\t\t%v\n", ByteSize{float64(rs.TotRespSize)})
+	if rs.TotH2SentSize > 0 || rs.TotH2RecvSize > 0 {
+		// h2 streams share their connection, so only their bodies are counted, apart from the wire bytes above.
+		result += fmt.Sprintf("HTTP/2 Body:\t\t%v read, %v written\n", ByteSize{float64(rs.TotH2RecvSize)}, ByteSize{float64(rs.TotH2SentSize)})
+	}
+	result += fmt.Sprintf("Requests/sec:\t\t%.2f\nDownload/sec:\t\t%v\nUpload/sec:\t\t%v\nAvg Req Time:\t\t%v (%s)\n", reqRate, ByteSize{downRate}, ByteSize{upRate}, avgReqTime, latencyMode)
 	result += fmt.Sprintf("Fastest Request:\t%v\n", rs.MinRequestTime)
 	result += fmt.Sprintf("Slowest Request:\t%v\n", rs.MaxRequestTime)
-	result += fmt.Sprintf("Number of Errors:\t%v\n", rs.NumErrs)
+	if rs.TTFB.Count > 0 {
+		result += fmt.Sprintf("DNS Lookup:\t\t%v\n", rs.DNS)
+		result += fmt.Sprintf("TCP Connect:\t\t%v\n", rs.Connect)
+		result += fmt.Sprintf("TLS Handshake:\t\t%v\n", rs.TLS)
+		result += fmt.Sprintf("Server Wait:\t\t%v\n", rs.Wait)
+		result += fmt.Sprintf("Time to First Byte:\t%v\n", rs.TTFB)
+		result += fmt.Sprintf("Content Transfer:\t%v\n", rs.Transfer)
+		result += fmt.Sprintf("Total Time:\t\t%v\n", rs.Total)
+	}
+	if rs.WSMessage.Count > 0 {
+		result += fmt.Sprintf("WS Message Latency:\t%v\n", rs.WSMessage)
+	}
+	if rs.WSConn.Count > 0 {
+		result += fmt.Sprintf("WS Connection:\t\t%v\n", rs.WSConn)
+	}
+	if rs.Events > 0 {
+		result += fmt.Sprintf("Events:\t\t\t%v\n", rs.Events)
+		result += fmt.Sprintf("First Event:\t\t%v\n", rs.FirstEvent)
+		result += fmt.Sprintf("Event Gap:\t\t%v\n", rs.EventGap)
+	}
+	if len(rs.Protos) > 0 {
+		result += fmt.Sprintf("Protocol:\t\t%v\n", rs.protoString())
+	}
+	result += rs.errString()
 
 	return result
 }
 
+func (rs *RequesterStats) errString() string {
+	result := fmt.Sprintf("Number of Errors:\t%v\n", rs.NumErrs)
+	if len(rs.ErrCategories) > 0 {
+		result += fmt.Sprintf("Error Categories:\t%v\n", countString(rs.ErrCategories))
+	}
+	return result
+}
+
 // PrintCsvHeader ...
 func PrintCsvHeader() string {
-	return "Title,Requests,Errors,avg Thread Duetime,Total RespSize,Avg RespSize,Requests/sec,Transfer/sec,Avg Req Time,Fastest Request,Slowest Request\n"
+	return "Title,Requests,Errors,avg Thread Duetime,Total Recv,Avg Recv,Requests/sec,Download/sec,Avg Req Time,Fastest Request,Slowest Request,Protocol,DNS,Connect,TLS,Wait,TTFB,Transfer,Total,Total Sent,Upload/sec,Decoded Body,H2 Body Recv,H2 Body Sent\n"
 }
 
 // PrintCSV ...
@@ -88,17 +286,20 @@ func (rs *RequesterStats) PrintCSV(responders int) string {
 	result += fmt.Sprintf("%v,%v,", rs.NumRequests, rs.NumErrs)
 
 	if rs.NumRequests == 0 {
-		result += "0,0,0,0,0,0,0,0"
+		result += "0,0,0,0,0,0,0,0,,0,0,0,0,0,0,0,0,0,0,0,0\n"
 	} else {
 		avgThreadDur := rs.TotDuration / time.Duration(responders) //need to average the aggregated duration
 
 		reqRate := float64(rs.NumRequests) / avgThreadDur.Seconds()
 		avgReqTime := rs.TotDuration / time.Duration(rs.NumRequests)
-		bytesRate := float64(rs.TotRespSize) / avgThreadDur.Seconds()
+		downRate := float64(rs.TotRecvSize) / avgThreadDur.Seconds()
+		upRate := float64(rs.TotSentSize) / avgThreadDur.Seconds()
 
-		result += fmt.Sprintf("%v,%v,%.2f,", avgThreadDur, rs.TotRespSize, float64(int(rs.TotRespSize)/rs.NumRequests))
-		result += fmt.Sprintf("%.2f,%.2f,%v,", reqRate, bytesRate, avgReqTime)
-		result += fmt.Sprintf("%v,%v\n", rs.MinRequestTime, rs.MaxRequestTime)
+		result += fmt.Sprintf("%v,%v,%.2f,", avgThreadDur, rs.TotRecvSize, float64(int(rs.TotRecvSize)/rs.NumRequests))
+		result += fmt.Sprintf("%.2f,%.2f,%v,", reqRate, downRate, avgReqTime)
+		result += fmt.Sprintf("%v,%v,%v,", rs.MinRequestTime, rs.MaxRequestTime, rs.protoString())
+		result += fmt.Sprintf("%v,%v,%v,%v,%v,%v,%v,", rs.DNS.Avg(), rs.Connect.Avg(), rs.TLS.Avg(), rs.Wait.Avg(), rs.TTFB.Avg(), rs.Transfer.Avg(), rs.Total.Avg())
+		result += fmt.Sprintf("%v,%.2f,%v,%v,%v\n", rs.TotSentSize, upRate, rs.TotRespSize, rs.TotH2RecvSize, rs.TotH2SentSize)
 	}
 	return result
 }
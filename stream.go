@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// stream task type
+const (
+	StreamSSE  = "sse"
+	StreamPoll = "poll"
+)
+
+func isStreamTask(task *Task) bool {
+	return task.Type == StreamSSE || task.Type == StreamPoll
+}
+
+// eventCounter count the events of a stream task.
+type eventCounter struct {
+	start time.Time
+	last  time.Time
+	res   *reqResult
+}
+
+func (c *eventCounter) event() {
+	now := time.Now()
+	if c.res.events == 0 {
+		c.res.firstEvent = now.Sub(c.start)
+	} else {
+		c.res.eventGap.add(now.Sub(c.last))
+	}
+	c.last = now
+	c.res.events++
+}
+
+// doStream run a stream task until duration_sec pass or events arrive.
+func (u *User) doStream(task *Task, reqURL string, headerList map[string]string, tr *TraceEntry) (*reqResult, error) {
+	if task.Type == StreamPoll {
+		return u.doPoll(task, reqURL, headerList, tr)
+	}
+	return doSSE(u.client, reqURL, task, headerList, u.parseParam(task.URLParam), tr)
+}
+
+// doSSE read the server-sent events of reqURL. the data of the last event is parsed as json for set_param.
+func doSSE(client *http.Client, reqURL string, task *Task, headerList map[string]string, urlParamList map[string]string, tr *TraceEntry) (*reqResult, error) {
+	if len(urlParamList) > 0 {
+		q := url.Values{}
+		for k, v := range urlParamList {
+			q.Add(k, v)
+		}
+		reqURL += "?" + q.Encode()
+	}
+
+	ctx := context.Background()
+	if task.DurationSec > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(task.DurationSec)*time.Second)
+		defer cancel()
+	}
+	start := time.Now()
+	timing := &reqTiming{start: start}
+	wire := &wireCount{}
+	ctx = withWire(timing.withTrace(ctx), wire)
+
+	method := task.Method
+	if method == "" {
+		method = "GET"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("An error occured http new request %s", err)
+	}
+	for key, val := range headerList {
+		req.Header.Add(key, val)
+	}
+	// no Accept-Encoding, the events are read as they arrive.
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if tr != nil {
+		tr.Start = start
+		tr.Method = req.Method
+		tr.URL = req.URL.String()
+		tr.ReqHeader = req.Header
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	ttfb := time.Since(start)
+
+	res := &reqResult{proto: resp.Proto, timing: timing}
+	counter := &eventCounter{start: start, res: res}
+	var readErr error
+	var last string
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		res.respSize = len(body)
+		last = string(body)
+		readErr = fmt.Errorf("resp status code err=%d body=%s", resp.StatusCode, string(body))
+	} else {
+		r := bufio.NewReader(resp.Body)
+		var data []string
+		for task.Events == 0 || res.events < task.Events {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				readErr = err
+				break
+			}
+			res.respSize += len(line)
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case line == "":
+				// a blank line dispatch the event.
+				if data != nil {
+					counter.event()
+					last = strings.Join(data, "\n")
+					data = nil
+				}
+			case line == "data" || strings.HasPrefix(line, "data:"):
+				val := strings.TrimPrefix(strings.TrimPrefix(line, "data"), ":")
+				data = append(data, strings.TrimPrefix(val, " "))
+			}
+		}
+	}
+
+	total := time.Since(start)
+	timing.mu.Lock()
+	timing.bodyDone = start.Add(total)
+	timing.mu.Unlock()
+	res.duration = pickLatency(ttfb, total)
+	res.total = total
+	res.setWire(resp, wire, 0, int64(res.respSize))
+	if last != "" {
+		json.Unmarshal([]byte(last), &res.parsed)
+	}
+	if tr != nil {
+		tr.Duration = total
+		tr.Proto = resp.Proto
+		tr.Status = resp.StatusCode
+		tr.RespHeader = resp.Header
+		tr.RespBody = last
+	}
+
+	switch {
+	case readErr == nil:
+	case ctx.Err() == context.DeadlineExceeded:
+		// duration_sec passed.
+	case readErr == io.EOF && (task.Events == 0 || res.events >= task.Events):
+	case readErr == io.EOF:
+		return res, fmt.Errorf("stream closed after %d of %d events", res.events, task.Events)
+	default:
+		return res, readErr
+	}
+	return res, nil
+}
+
+// doPoll send the request again as each long polling response arrive, a response is an event.
+func (u *User) doPoll(task *Task, reqURL string, headerList map[string]string, tr *TraceEntry) (*reqResult, error) {
+	start := time.Now()
+	deadline := start.Add(time.Duration(task.DurationSec) * time.Second)
+	res := &reqResult{}
+	counter := &eventCounter{start: start, res: res}
+	var err error
+	for task.Events == 0 || res.events < task.Events {
+		client := u.client
+		if task.DurationSec > 0 {
+			remain := time.Until(deadline)
+			if remain <= 0 {
+				break
+			}
+			// the last request end at duration_sec.
+			limited := *u.client
+			limited.Timeout = remain
+			client = &limited
+		}
+		var r *reqResult
+		r, err = doRequest(client, reqURL, task.Method, headerList, u.parseParam(task.URLParam), u.parseParam(task.Body), nil, task.Compress, tr)
+		if r != nil {
+			res.respSize += r.respSize
+			res.sent += r.sent
+			res.recv += r.recv
+			res.h2Sent += r.h2Sent
+			res.h2Recv += r.h2Recv
+			res.proto = r.proto
+		}
+		if err != nil {
+			if task.DurationSec > 0 && !time.Now().Before(deadline) {
+				// duration_sec passed.
+				err = nil
+			}
+			break
+		}
+		counter.event()
+		res.parsed = r.parsed
+	}
+
+	res.total = time.Since(start)
+	res.duration = res.total
+	if tr != nil {
+		tr.Start = start
+		tr.Duration = res.total
+	}
+	return res, err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStreamTasks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/poll" {
+			time.Sleep(10 * time.Millisecond)
+			w.Write([]byte(`{"seq":1}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 1; i <= 3; i++ {
+			fmt.Fprintf(w, ": keep alive\nevent: notice\ndata: {\"seq\":%d}\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	u := &User{param: map[string]string{}, client: newHTTPClient()}
+
+	res, err := u.doTask(&Task{Type: StreamSSE, Events: 2}, srv.URL+"/sse", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.events != 2 || res.firstEvent <= 0 || res.eventGap.Count != 1 || res.parsed["seq"] != float64(2) {
+		t.Errorf("sse result %+v", res)
+	}
+
+	if _, err := u.doTask(&Task{Type: StreamSSE, Events: 5}, srv.URL+"/sse", nil, nil); err == nil {
+		t.Error("stream closed before events not failed")
+	}
+
+	res, err = u.doTask(&Task{Type: StreamPoll, Events: 3}, srv.URL+"/poll", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.events != 3 || res.eventGap.Count != 2 || res.eventGap.Max < 10*time.Millisecond {
+		t.Errorf("poll result %+v", res)
+	}
+}
+
+func TestPollDuration(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(700 * time.Millisecond)
+		w.Write([]byte(`{"seq":1}`))
+	}))
+	defer srv.Close()
+
+	u := &User{param: map[string]string{}, client: newHTTPClient()}
+	res, err := u.doTask(&Task{Type: StreamPoll, DurationSec: 1}, srv.URL, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second response would arrive at 1.4s.
+	if res.events != 1 || res.total > 1200*time.Millisecond {
+		t.Errorf("poll overrun duration_sec: events %d total %v", res.events, res.total)
+	}
+}
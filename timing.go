@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// reqTiming phases of a request collected by httptrace.
+type reqTiming struct {
+	mu sync.Mutex
+
+	start      time.Time
+	dnsStart   time.Time
+	connStart  time.Time
+	tlsStart   time.Time
+	wrote      time.Time
+	firstByte  time.Time
+	bodyDone   time.Time
+	dns        time.Duration
+	connect    time.Duration
+	tls        time.Duration
+	reusedConn bool
+}
+
+// withTrace return ctx traced by t.
+func (t *reqTiming) withTrace(ctx context.Context) context.Context {
+	lock := func(f func()) {
+		t.mu.Lock()
+		f()
+		t.mu.Unlock()
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			lock(func() { t.reusedConn = info.Reused })
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			lock(func() { t.dnsStart = time.Now() })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			lock(func() { t.dns = time.Since(t.dnsStart) })
+		},
+		ConnectStart: func(network, addr string) {
+			lock(func() { t.connStart = time.Now() })
+		},
+		ConnectDone: func(network, addr string, err error) {
+			lock(func() { t.connect = time.Since(t.connStart) })
+		},
+		TLSHandshakeStart: func() {
+			lock(func() { t.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			lock(func() { t.tls = time.Since(t.tlsStart) })
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			lock(func() { t.wrote = time.Now() })
+		},
+		GotFirstResponseByte: func() {
+			lock(func() { t.firstByte = time.Now() })
+		},
+	})
+}
+
+// phases return dns, connect, tls, wait (request written -> first byte), ttfb and transfer (first byte -> body read).
+func (t *reqTiming) phases() (dns, connect, tls, wait, ttfb, transfer time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	dns, connect, tls = t.dns, t.connect, t.tls
+	if !t.firstByte.IsZero() {
+		ttfb = t.firstByte.Sub(t.start)
+		if !t.wrote.IsZero() {
+			wait = t.firstByte.Sub(t.wrote)
+		}
+		if !t.bodyDone.IsZero() {
+			transfer = t.bodyDone.Sub(t.firstByte)
+		}
+	}
+	return
+}
+
+// Phase aggregate durations of a request phase.
+type Phase struct {
+	Total time.Duration
+	Count int
+	Max   time.Duration
+}
+
+func (p *Phase) add(d time.Duration) {
+	p.Total += d
+	p.Count++
+	p.Max = MaxDuration(p.Max, d)
+}
+
+func (p *Phase) merge(o Phase) {
+	p.Total += o.Total
+	p.Count += o.Count
+	p.Max = MaxDuration(p.Max, o.Max)
+}
+
+// Avg ...
+func (p Phase) Avg() time.Duration {
+	if p.Count == 0 {
+		return 0
+	}
+	return p.Total / time.Duration(p.Count)
+}
+
+func (p Phase) String() string {
+	if p.Count == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("avg %v max %v (%d)", p.Avg(), p.Max, p.Count)
+}
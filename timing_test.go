@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestTiming(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{}`))
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+
+	for _, srv := range []*httptest.Server{plain, secure} {
+		client := &http.Client{Transport: newTLSTransport(&tls.Config{InsecureSkipVerify: true}, newDialFunc(nil))}
+		// localhost so the dns lookup is traced too
+		reqURL := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+		isTLS := srv == secure
+
+		res, err := doRequest(client, reqURL, "GET", nil, nil, nil, nil, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dns, connect, tlsDur, wait, ttfb, transfer := res.timing.phases()
+		if dns <= 0 || connect <= 0 || (tlsDur > 0) != isTLS || wait < 20*time.Millisecond || transfer < 0 {
+			t.Errorf("tls=%v phases dns %v connect %v tls %v wait %v transfer %v", isTLS, dns, connect, tlsDur, wait, transfer)
+		}
+		if dns+connect+tlsDur+wait > ttfb || ttfb > res.total {
+			t.Errorf("tls=%v phases not ordered: dns+connect+tls+wait %v ttfb %v total %v", isTLS, dns+connect+tlsDur+wait, ttfb, res.total)
+		}
+
+		// the reused connection has no dns, connect and tls phase.
+		res, err = doRequest(client, reqURL, "GET", nil, nil, nil, nil, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dns, connect, tlsDur, _, ttfb, _ = res.timing.phases()
+		if !res.timing.reusedConn || dns != 0 || connect != 0 || tlsDur != 0 || ttfb <= 0 {
+			t.Errorf("tls=%v reused conn phases dns %v connect %v tls %v ttfb %v", isTLS, dns, connect, tlsDur, ttfb)
+		}
+	}
+}
+
+func TestLatencyMode(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(30 * time.Millisecond)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	defer func() { latencyMode = "" }()
+
+	client := &http.Client{Transport: newTLSTransport(nil, newDialFunc(nil))}
+	for _, mode := range []string{LatencyTTFB, LatencyTotal} {
+		latencyMode = mode
+		res, err := doRequest(client, srv.URL, "GET", nil, nil, nil, nil, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.total < 30*time.Millisecond {
+			t.Errorf("%s total %v without the body", mode, res.total)
+		}
+		if mode == LatencyTTFB && res.duration > res.total-20*time.Millisecond {
+			t.Errorf("ttfb duration %v total %v", res.duration, res.total)
+		}
+		if mode == LatencyTotal && res.duration != res.total {
+			t.Errorf("total duration %v total %v", res.duration, res.total)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// TLSConfig ...
+type TLSConfig struct {
+	CAFile             string   `json:"ca_file"`
+	CertFile           string   `json:"cert_file"`
+	KeyFile            string   `json:"key_file"`
+	InsecureSkipVerify bool     `json:"insecure_skip_verify"`
+	MinVersion         string   `json:"min_version"`
+	CipherSuites       []string `json:"cipher_suites"`
+	ServerName         string   `json:"server_name"`
+}
+
+var (
+	tlsConfig     *tls.Config
+	hostTLSConfig map[string]*tls.Config
+
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+)
+
+// merge return a copy of c overridden by the set fields of o.
+func (c *TLSConfig) merge(o *TLSConfig) *TLSConfig {
+	result := &TLSConfig{}
+	if c != nil {
+		*result = *c
+	}
+	if o.CAFile != "" {
+		result.CAFile = o.CAFile
+	}
+	if o.CertFile != "" {
+		result.CertFile = o.CertFile
+	}
+	if o.KeyFile != "" {
+		result.KeyFile = o.KeyFile
+	}
+	if o.InsecureSkipVerify {
+		result.InsecureSkipVerify = true
+	}
+	if o.MinVersion != "" {
+		result.MinVersion = o.MinVersion
+	}
+	if len(o.CipherSuites) > 0 {
+		result.CipherSuites = o.CipherSuites
+	}
+	if o.ServerName != "" {
+		result.ServerName = o.ServerName
+	}
+	return result
+}
+
+// resolvePath make the file paths relative to dir.
+func (c *TLSConfig) resolvePath(dir string) {
+	for _, p := range []*string{&c.CAFile, &c.CertFile, &c.KeyFile} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+}
+
+// Build ...
+func (c *TLSConfig) Build() (*tls.Config, error) {
+	cfg := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		ServerName:         c.ServerName,
+	}
+
+	if c.CAFile != "" {
+		data, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate in ca file %s", c.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client cert %s key %s: %s", c.CertFile, c.KeyFile, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.MinVersion != "" {
+		ver, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("not support tls version %q", c.MinVersion)
+		}
+		cfg.MinVersion = ver
+	}
+
+	if len(c.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[s.Name] = s.ID
+		}
+		for _, name := range c.CipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("not support cipher suite %q", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+	return cfg, nil
+}
+
+// initTLS build tlsConfig from the senario and the command line, and hostTLSConfig from senario host_tls.
+func initTLS(s *Scenario) error {
+	flagTLS := &TLSConfig{
+		CAFile:             tlsCAFile,
+		CertFile:           tlsCertFile,
+		KeyFile:            tlsKeyFile,
+		InsecureSkipVerify: tlsInsecure,
+		MinVersion:         tlsMinVersion,
+		ServerName:         tlsServerName,
+	}
+	if tlsCiphers != "" {
+		flagTLS.CipherSuites = strings.Split(tlsCiphers, ",")
+	}
+
+	var base *TLSConfig
+	if s != nil && s.TLS != nil {
+		base = s.TLS
+	}
+	global := base.merge(flagTLS)
+	var err error
+	if tlsConfig, err = global.Build(); err != nil {
+		return err
+	}
+
+	hostTLSConfig = make(map[string]*tls.Config)
+	if s == nil {
+		return nil
+	}
+	for name, c := range s.HostTLS {
+		addr, ok := s.Hosts[name]
+		if !ok {
+			return fmt.Errorf("host_tls: unknown host %q", name)
+		}
+		u, err := url.Parse(addr)
+		if err != nil {
+			return fmt.Errorf("host_tls: host %q: %s", name, err)
+		}
+		cfg, err := global.merge(c).Build()
+		if err != nil {
+			return fmt.Errorf("host_tls: host %q: %s", name, err)
+		}
+		hostTLSConfig[u.Host] = cfg
+	}
+	return nil
+}
+
+// hostTransport send requests to the hosts of host_tls by their own transport.
+type hostTransport struct {
+	def   http.RoundTripper
+	hosts map[string]http.RoundTripper
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt, ok := t.hosts[req.URL.Host]; ok {
+		return rt.RoundTrip(req)
+	}
+	return t.def.RoundTrip(req)
+}
+
+func (t *hostTransport) CloseIdleConnections() {
+	closeIdle(t.def)
+	for _, rt := range t.hosts {
+		closeIdle(rt)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSConfigBuild(t *testing.T) {
+	cfg, err := (&TLSConfig{
+		MinVersion:   "1.2",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		ServerName:   "api.example.com",
+	}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || len(cfg.CipherSuites) != 1 || cfg.ServerName != "api.example.com" {
+		t.Errorf("build err %+v", cfg)
+	}
+
+	if _, err := (&TLSConfig{MinVersion: "2.0"}).Build(); err == nil {
+		t.Error("bad version not rejected")
+	}
+	if _, err := (&TLSConfig{CipherSuites: []string{"NOPE"}}).Build(); err == nil {
+		t.Error("bad cipher not rejected")
+	}
+}
+
+func TestTLSConfigCA(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := (&TLSConfig{CAFile: caFile}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: newTLSTransport(cfg, newDialFunc(nil))}
+	if _, err := doRequest(client, srv.URL, "GET", nil, nil, nil, nil, "", nil); err != nil {
+		t.Error("request with ca err", err)
+	}
+}
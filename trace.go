@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const traceBodyLimit = 1024
+
+// TraceEntry a request/response exchange of a traced task.
+type TraceEntry struct {
+	UserIdx    uint32
+	Step       string
+	Start      time.Time
+	Duration   time.Duration
+	Method     string
+	URL        string
+	ReqHeader  http.Header
+	ReqBody    string
+	Proto      string
+	Status     int
+	RespHeader http.Header
+	RespBody   string
+	SetParam   map[string]string
+	Err        error
+}
+
+// Tracer print the exchanges of the first limit pre and run iterations.
+type Tracer struct {
+	limit  int32
+	preCnt int32
+	runCnt int32
+
+	mu      sync.Mutex
+	out     io.Writer
+	entries []*TraceEntry
+}
+
+// NewTracer ...
+func NewTracer(limit int) *Tracer {
+	return &Tracer{
+		limit: int32(limit),
+		out:   os.Stdout,
+	}
+}
+
+// BeginPre return true if the next Pre should be traced.
+func (t *Tracer) BeginPre() bool {
+	return atomic.AddInt32(&t.preCnt, 1) <= t.limit
+}
+
+// BeginRun return true if the next Run iteration should be traced.
+func (t *Tracer) BeginRun() bool {
+	return atomic.AddInt32(&t.runCnt, 1) <= t.limit
+}
+
+// Record print the entry and keep it for the har file.
+func (t *Tracer) Record(e *TraceEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.entries = append(t.entries, e)
+	fmt.Fprint(t.out, e.String())
+}
+
+func (e *TraceEntry) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%04d] %s %s %s (%v)\n", e.UserIdx, e.Step, e.Method, e.URL, e.Duration)
+	writeTraceHeader(&b, "  > ", e.ReqHeader)
+	if e.ReqBody != "" {
+		fmt.Fprintf(&b, "  > %s\n", e.ReqBody)
+	}
+	if e.Status != 0 {
+		fmt.Fprintf(&b, "  < %s %d %s\n", e.Proto, e.Status, http.StatusText(e.Status))
+		writeTraceHeader(&b, "  < ", e.RespHeader)
+		if e.RespBody != "" {
+			body := e.RespBody
+			if len(body) > traceBodyLimit {
+				body = fmt.Sprintf("%s...(%d bytes)", body[:traceBodyLimit], len(body))
+			}
+			fmt.Fprintf(&b, "  < %s\n", body)
+		}
+	}
+	keys := make([]string, 0, len(e.SetParam))
+	for k := range e.SetParam {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "  = %s: %s\n", k, e.SetParam[k])
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, "  ! %s\n", e.Err)
+	}
+	return b.String()
+}
+
+func writeTraceHeader(w io.Writer, prefix string, header http.Header) {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			fmt.Fprintf(w, "%s%s: %s\n", prefix, k, v)
+		}
+	}
+}
+
+// har 1.2 format. http://www.softwareishard.com/blog/har-12-spec/
+type harLog struct {
+	Log struct {
+		Version string `json:"version"`
+		Creator struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"creator"`
+		Entries []*harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harEntry struct {
+	StartedDateTime string `json:"startedDateTime"`
+	Time            int64  `json:"time"`
+	Request         struct {
+		Method      string          `json:"method"`
+		URL         string          `json:"url"`
+		HTTPVersion string          `json:"httpVersion"`
+		Headers     []*harNameValue `json:"headers"`
+		QueryString []*harNameValue `json:"queryString"`
+		PostData    *harPostData    `json:"postData,omitempty"`
+		HeadersSize int             `json:"headersSize"`
+		BodySize    int             `json:"bodySize"`
+	} `json:"request"`
+	Response struct {
+		Status      int             `json:"status"`
+		StatusText  string          `json:"statusText"`
+		HTTPVersion string          `json:"httpVersion"`
+		Headers     []*harNameValue `json:"headers"`
+		Content     struct {
+			Size     int    `json:"size"`
+			MimeType string `json:"mimeType"`
+			Text     string `json:"text"`
+		} `json:"content"`
+		RedirectURL string `json:"redirectURL"`
+		HeadersSize int    `json:"headersSize"`
+		BodySize    int    `json:"bodySize"`
+	} `json:"response"`
+	Cache   struct{} `json:"cache"`
+	Timings struct {
+		Send    int64 `json:"send"`
+		Wait    int64 `json:"wait"`
+		Receive int64 `json:"receive"`
+	} `json:"timings"`
+	Comment string `json:"comment,omitempty"`
+}
+
+func harHeaders(header http.Header) []*harNameValue {
+	result := []*harNameValue{}
+	for k, vs := range header {
+		for _, v := range vs {
+			result = append(result, &harNameValue{Name: k, Value: v})
+		}
+	}
+	return result
+}
+
+// WriteHAR write every recorded exchange to filePath.
+func (t *Tracer) WriteHAR(filePath string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	har := &harLog{}
+	har.Log.Version = "1.2"
+	har.Log.Creator.Name = "bust-wrk"
+	har.Log.Creator.Version = "1.0"
+	har.Log.Entries = []*harEntry{}
+
+	for _, e := range t.entries {
+		he := &harEntry{
+			StartedDateTime: e.Start.Format(time.RFC3339Nano),
+			Time:            e.Duration.Milliseconds(),
+			Comment:         fmt.Sprintf("user %d step %s", e.UserIdx, e.Step),
+		}
+		he.Request.Method = e.Method
+		he.Request.URL = e.URL
+		// the request and response share the protocol of the connection.
+		he.Request.HTTPVersion = e.Proto
+		if e.Proto == "" {
+			he.Request.HTTPVersion = "HTTP/1.1"
+		}
+		he.Request.Headers = harHeaders(e.ReqHeader)
+		he.Request.QueryString = []*harNameValue{}
+		if u, err := url.Parse(e.URL); err == nil {
+			for k, vs := range u.Query() {
+				for _, v := range vs {
+					he.Request.QueryString = append(he.Request.QueryString, &harNameValue{Name: k, Value: v})
+				}
+			}
+		}
+		he.Request.HeadersSize = -1
+		he.Request.BodySize = len(e.ReqBody)
+		if e.ReqBody != "" {
+			he.Request.PostData = &harPostData{MimeType: e.ReqHeader.Get("Content-Type"), Text: e.ReqBody}
+		}
+
+		he.Response.Status = e.Status
+		he.Response.StatusText = http.StatusText(e.Status)
+		he.Response.HTTPVersion = he.Request.HTTPVersion
+		he.Response.Headers = harHeaders(e.RespHeader)
+		he.Response.Content.Size = len(e.RespBody)
+		he.Response.Content.MimeType = e.RespHeader.Get("Content-Type")
+		he.Response.Content.Text = e.RespBody
+		he.Response.HeadersSize = -1
+		he.Response.BodySize = len(e.RespBody)
+		he.Timings.Wait = he.Time
+		if e.Err != nil {
+			he.Comment += " err=" + e.Err.Error()
+		}
+		har.Log.Entries = append(har.Log.Entries, he)
+	}
+
+	data, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, data, 0644)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTracerLimit(t *testing.T) {
+	tracer := NewTracer(2)
+	for i, want := range []bool{true, true, false} {
+		if got := tracer.BeginPre(); got != want {
+			t.Errorf("pre %d traced=%v", i, got)
+		}
+	}
+	for i, want := range []bool{true, true, false, false} {
+		if got := tracer.BeginRun(); got != want {
+			t.Errorf("run %d traced=%v", i, got)
+		}
+	}
+}
+
+func TestTracerRecord(t *testing.T) {
+	var out bytes.Buffer
+	tracer := NewTracer(1)
+	tracer.out = &out
+
+	tracer.Record(&TraceEntry{
+		UserIdx:    3,
+		Step:       "login",
+		Start:      time.Now(),
+		Duration:   15 * time.Millisecond,
+		Method:     "POST",
+		URL:        "http://127.0.0.1/login?region=kr",
+		ReqHeader:  http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+		ReqBody:    "id=tester",
+		Proto:      "HTTP/2.0",
+		Status:     200,
+		RespHeader: http.Header{"Content-Type": {"application/json"}},
+		RespBody:   `{"token":"tok"}`,
+		SetParam:   map[string]string{"[ACCESS_TOKEN]": "tok"},
+	})
+	tracer.Record(&TraceEntry{UserIdx: 3, Step: "info", Method: "GET", URL: "http://127.0.0.1/info", Err: errors.New("refused")})
+
+	for _, want := range []string{
+		"[0003] login POST http://127.0.0.1/login?region=kr (15ms)",
+		"  > Content-Type: application/x-www-form-urlencoded",
+		"  < HTTP/2.0 200 OK",
+		"  = [ACCESS_TOKEN]: tok",
+		"  ! refused",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("trace output has no %q\n%s", want, out.String())
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "trace.har")
+	if err := tracer.WriteHAR(file); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	har := &harLog{}
+	if err := json.Unmarshal(data, har); err != nil {
+		t.Fatal(err)
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 2 {
+		t.Fatalf("har log %+v", har.Log)
+	}
+	login := har.Log.Entries[0]
+	if login.Request.HTTPVersion != "HTTP/2.0" || login.Response.HTTPVersion != "HTTP/2.0" || login.Time != 15 {
+		t.Errorf("har version %+v", login)
+	}
+	if len(login.Request.QueryString) != 1 || login.Request.QueryString[0].Value != "kr" || login.Request.PostData.Text != "id=tester" {
+		t.Errorf("har request %+v", login.Request)
+	}
+	if login.Response.Status != 200 || login.Response.Content.MimeType != "application/json" || login.Response.Content.Text != `{"token":"tok"}` {
+		t.Errorf("har response %+v", login.Response)
+	}
+	info := har.Log.Entries[1]
+	if info.Request.HTTPVersion != "HTTP/1.1" || !strings.Contains(info.Comment, "err=refused") {
+		t.Errorf("har failed entry %+v", info)
+	}
+}
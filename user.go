@@ -3,19 +3,138 @@ package main
 import (
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
+
+	"google.golang.org/grpc"
 )
 
 // User ...
 type User struct {
-	idx      uint32
-	param    map[string]string
-	respSize int
-	client   *http.Client
-	cycle    int
+	idx       uint32
+	param     map[string]string
+	respSize  int
+	sentSize  int64
+	recvSize  int64
+	client    *http.Client
+	cycle     int
+	tracing   bool
+	header    map[string]string
+	hosts     map[string]string
+	hostStats bool
+	local     net.IP // source ip of -bind-mode user, nil to rotate -bind per connection
+
+	clearCookies bool
+	ws           *wsConn
+	sock         *sockConn
+	grpcConns    map[string]*grpc.ClientConn // with -conn-mode user
+
+	auth        *AuthConfig
+	tokenExpiry time.Time
+
+	// preCookies cookies set during pre, restored by resetCookies.
+	preCookies []cookieSet
+
+	// h2SentSize and h2RecvSize the h2 bodies, apart from sentSize and recvSize on the wire.
+	h2SentSize int64
+	h2RecvSize int64
+}
+
+// Close release the connections of the user. only with -conn-mode user the user own them.
+func (u *User) Close() {
+	if u.ws != nil {
+		u.ws.conn.Close()
+		u.ws = nil
+	}
+	if u.sock != nil {
+		u.sock.conn.Close()
+		u.sock = nil
+	}
+	for target, conn := range u.grpcConns {
+		conn.Close()
+		delete(u.grpcConns, target)
+	}
+	if connMode == ConnPerUser {
+		u.client.CloseIdleConnections()
+	}
+}
+
+// requestURL return the url of the task. websocket and socket tasks after the connect use the connected url.
+func (u *User) requestURL(task *Task) string {
+	if task.Type == WSConnect {
+		return wsURL(u.taskURL(task))
+	}
+	if isWSTask(task) {
+		if u.ws == nil {
+			return ""
+		}
+		return u.ws.url
+	}
+	if isSocketTask(task) && task.Type != SocketConnect {
+		if u.sock == nil {
+			return ""
+		}
+		return u.sock.url
+	}
+	return u.taskURL(task)
+}
+
+// doTask send the request of the task by its type.
+func (u *User) doTask(task *Task, reqURL string, headerList map[string]string, tr *TraceEntry) (*reqResult, error) {
+	if isWSTask(task) {
+		return u.doWS(task, reqURL, headerList, tr)
+	}
+	if isStreamTask(task) {
+		return u.doStream(task, reqURL, headerList, tr)
+	}
+	if task.Type == TaskGRPC {
+		return u.doGRPC(task, reqURL, headerList, tr)
+	}
+	if isSocketTask(task) {
+		return u.doSocket(task, reqURL, tr)
+	}
+	if task.Type == TaskGraphQL {
+		return u.doGraphQL(task, reqURL, headerList, tr)
+	}
+	return doRequest(u.client, reqURL, task.Method, headerList, u.parseParam(task.URLParam), u.parseParam(task.Body), u.parseFiles(task.Files), task.Compress, tr)
+}
+
+// taskURL return the request url of the task. relative url is resolved by the task host or -s.
+func (u *User) taskURL(task *Task) string {
+	newURL := u.replaceParam(task.URL)
+	if isAbsURL(newURL) {
+		return newURL
+	}
+	if task.Host != "" {
+		addr, ok := u.hosts[task.Host]
+		if !ok {
+			log.Fatalln("not found host", task.Host)
+		}
+		return addr + newURL
+	}
+	if balancer != nil {
+		return balancer.Pick(u.idx) + newURL
+	}
+	return srvaddr + newURL
+}
+
+// sendStats send the step stats, and a copy titled by the target host when the scenario use several hosts or servers.
+func (u *User) sendStats(stats *RequesterStats, reqURL string) {
+	statsAggregator <- stats
+	if !u.hostStats {
+		return
+	}
+	hostStats := stats.clone()
+	hostStats.Title = "host " + reqURL
+	if parsed, err := url.Parse(reqURL); err == nil {
+		hostStats.Title = "host " + parsed.Host
+	}
+	statsAggregator <- hostStats
 }
 
 func (u *User) replaceParam(param string) string {
@@ -53,20 +172,41 @@ func (u *User) parseParam(param map[string]string) map[string]string {
 	return result
 }
 
-func (u *User) setParam(srcParam map[string]string, destParam map[string]interface{}) {
+// parseFiles return the file parts with the params of the filename replaced.
+func (u *User) parseFiles(files map[string]*FilePart) map[string]*FilePart {
+	if len(files) == 0 {
+		return nil
+	}
+	result := make(map[string]*FilePart)
+	for k, p := range files {
+		newPart := *p
+		newPart.Name = u.replaceParam(p.Name)
+		result[k] = &newPart
+	}
+	return result
+}
+
+func (u *User) setParam(srcParam map[string]string, destParam map[string]interface{}, reqURL string) {
 	for k, v := range srcParam {
 		newKey := k[1 : len(k)-1]
 
+		// "cookie:NAME" read the cookie of the user jar for the request url.
+		if strings.HasPrefix(v, cookieParamPrefix) {
+			u.param[newKey] = u.cookie(reqURL, v[len(cookieParamPrefix):])
+			continue
+		}
+
 		var vTemp interface{}
 		vList := strings.Split(v, ".")
 
+		node := destParam
 		for i := 0; i < len(vList); i++ {
 			newValKey := vList[i]
 			if i == len(vList)-1 {
-				vTemp = destParam[newValKey]
+				vTemp = node[newValKey]
 				break
 			} else {
-				destParam = destParam[newValKey].(map[string]interface{})
+				node = node[newValKey].(map[string]interface{})
 			}
 		}
 
@@ -83,26 +223,113 @@ func (u *User) setParam(srcParam map[string]string, destParam map[string]interfa
 	}
 }
 
+const cookieParamPrefix = "cookie:"
+
+func (u *User) cookie(reqURL string, name string) string {
+	if u.client.Jar == nil {
+		return ""
+	}
+	parsed, err := url.Parse(reqURL)
+	if err != nil {
+		return ""
+	}
+	for _, c := range u.client.Jar.Cookies(parsed) {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// resetCookies give the user a cookie jar with only the cookies set during pre.
+func (u *User) resetCookies() {
+	jar, _ := cookiejar.New(nil)
+	for _, set := range u.preCookies {
+		jar.SetCookies(set.url, set.cookies)
+	}
+	u.client.Jar = jar
+}
+
+type cookieSet struct {
+	url     *url.URL
+	cookies []*http.Cookie
+}
+
+// recordJar record the cookies set on the jar.
+type recordJar struct {
+	http.CookieJar
+	sets []cookieSet
+}
+
+func (j *recordJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.sets = append(j.sets, cookieSet{url: u, cookies: cookies})
+	j.CookieJar.SetCookies(u, cookies)
+}
+
+// makeHeader return the env headers and the token header if useToken.
+func (u *User) makeHeader(useToken bool) map[string]string {
+	headerList := make(map[string]string)
+	for key, val := range u.header {
+		headerList[key] = u.replaceParam(val)
+	}
+	if useToken {
+		key, val := u.auth.tokenHeader(u.param["ACCESS_TOKEN"])
+		headerList[key] = val
+	}
+	return headerList
+}
+
+func (u *User) newTrace(step string) *TraceEntry {
+	if !u.tracing {
+		return nil
+	}
+	return &TraceEntry{UserIdx: u.idx, Step: step}
+}
+
+func (u *User) endTrace(tr *TraceEntry, setParam map[string]string, err error) {
+	if tr == nil {
+		return
+	}
+	if err == nil && len(setParam) > 0 {
+		tr.SetParam = make(map[string]string)
+		for k := range setParam {
+			tr.SetParam[k] = u.param[k[1:len(k)-1]]
+		}
+	}
+	tr.Err = err
+	tracer.Record(tr)
+}
+
 // Pre ...
 func (u *User) Pre(preSenario []*Task) (time.Duration, error) {
 
+	u.tracing = tracer != nil && tracer.BeginPre()
+
+	// with clear_cookies every run iteration start from the cookies of pre.
+	if u.clearCookies && u.client.Jar != nil {
+		rec := &recordJar{CookieJar: u.client.Jar}
+		u.client.Jar = rec
+		defer func() {
+			u.client.Jar = rec.CookieJar
+			u.preCookies = append(u.preCookies, rec.sets...)
+		}()
+	}
+
 	start := time.Now()
 	for _, task := range preSenario {
 
-		newURL := u.replaceParam(task.URL)
+		reqURL := u.requestURL(task)
 		// log.Printf("[%4d] start task=%v\n", u.idx, task)
-		var headerList map[string]string
-		if task.UseToken {
-			headerList = make(map[string]string)
-			headerList["Authorization"] = "Bearer " + u.param["ACCESS_TOKEN"]
-		}
 
-		res, _, _, err := doRequest(u.client, srvaddr+newURL, task.Method, headerList, u.parseParam(task.URLParam), u.parseParam(task.Body))
+		tr := u.newTrace(task.Step)
+		res, err := u.doAuthTask(task, reqURL, tr, false)
 		if err != nil {
+			u.endTrace(tr, nil, err)
 			log.Printf("[%04d] task=%+v err=%s\n", u.idx, task, err)
 			break
 		}
-		u.setParam(task.SetParam, res)
+		u.setParam(task.SetParam, res.parsed, reqURL)
+		u.endTrace(tr, task.SetParam, nil)
 		//log.Printf("[%4d] nick:%s due=%f\n", u.idx, userID, due.Seconds())
 	}
 
@@ -132,6 +359,14 @@ func parseWaitSec(str string) int {
 // Run ...
 func (u *User) Run(runSenario, preSteps []*Task) (time.Duration, error) {
 	u.respSize = 0
+	u.sentSize = 0
+	u.recvSize = 0
+	u.h2SentSize = 0
+	u.h2RecvSize = 0
+	u.tracing = tracer != nil && tracer.BeginRun()
+	if u.clearCookies {
+		u.resetCookies()
+	}
 	start := time.Now()
 
 	for _, task := range runSenario {
@@ -141,24 +376,22 @@ func (u *User) Run(runSenario, preSteps []*Task) (time.Duration, error) {
 		u.param["STEP_NAME"] = task.Step
 
 		for _, step := range preSteps {
-			if step.URL != "" {
-				newURL := u.replaceParam(step.URL)
-
-				var headerList map[string]string
-				if step.UseToken {
-					if u.param["ACCESS_TOKEN"] == "" {
-						continue
-					}
-					headerList = make(map[string]string)
-					headerList["Authorization"] = "Bearer " + u.param["ACCESS_TOKEN"]
+			if step.URL != "" || step.Type != "" {
+				reqURL := u.requestURL(step)
+
+				if step.UseToken && u.auth == nil && u.param["ACCESS_TOKEN"] == "" {
+					continue
 				}
 
-				res, _, _, err := doRequest(u.client, srvaddr+newURL, step.Method, headerList, u.parseParam(step.URLParam), u.parseParam(step.Body))
+				tr := u.newTrace(task.Step + " pre_step")
+				res, err := u.doAuthTask(step, reqURL, tr, true)
 				if err != nil {
-					log.Printf("[%04d] url=%s err=%s\n", u.idx, srvaddr+newURL, err)
+					u.endTrace(tr, nil, err)
+					log.Printf("[%04d] url=%s err=%s\n", u.idx, reqURL, err)
 					break
 				}
-				u.setParam(step.SetParam, res)
+				u.setParam(step.SetParam, res.parsed, reqURL)
+				u.endTrace(tr, step.SetParam, nil)
 			}
 
 			if sec := parseWaitSec(step.WaitSec); sec > 0 {
@@ -166,32 +399,36 @@ func (u *User) Run(runSenario, preSteps []*Task) (time.Duration, error) {
 			}
 		}
 
-		if task.URL != "" {
+		if task.URL != "" || task.Type != "" {
 			// log.Printf("[%4d] start task=%v\n", u.idx, task)
 			stats := &RequesterStats{Title: task.Step, MinRequestTime: time.Minute}
 
-			newURL := u.replaceParam(task.URL)
+			reqURL := u.requestURL(task)
 
-			var headerList map[string]string
-			if task.UseToken {
-				headerList = make(map[string]string)
-				headerList["Authorization"] = "Bearer " + u.param["ACCESS_TOKEN"]
-			}
-
-			res, due, respSize, err := doRequest(u.client, srvaddr+newURL, task.Method, headerList, u.parseParam(task.URLParam), u.parseParam(task.Body))
+			tr := u.newTrace(task.Step)
+			res, err := u.doAuthTask(task, reqURL, tr, true)
 			if err != nil {
-				stats.Err()
-				statsAggregator <- stats
-				log.Printf("[%04d] url=%s err=%s\n", u.idx, srvaddr+newURL, err)
+				u.endTrace(tr, nil, err)
+				stats.Err(err)
+				if res != nil {
+					stats.Wire(res)
+				}
+				u.sendStats(stats, reqURL)
+				log.Printf("[%04d] url=%s err=%s\n", u.idx, reqURL, err)
 				break
 			}
-			u.respSize += respSize
+			u.respSize += res.respSize
+			u.sentSize += res.sent
+			u.recvSize += res.recv
+			u.h2SentSize += res.h2Sent
+			u.h2RecvSize += res.h2Recv
 
-			u.setParam(task.SetParam, res)
+			u.setParam(task.SetParam, res.parsed, reqURL)
+			u.endTrace(tr, task.SetParam, nil)
 
 			//log.Printf("[%4d] nick:%s due=%f\n", u.idx, userID, due.Seconds())
-			stats.Calc(due, respSize)
-			statsAggregator <- stats
+			stats.Calc(res)
+			u.sendStats(stats, reqURL)
 		}
 
 		if sec := parseWaitSec(task.WaitSec); sec > 0 {
@@ -1,6 +1,11 @@
 package main
 
-import "testing"
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
 
 func TestReplaceParam(t *testing.T) {
 	u := &User{}
@@ -22,3 +27,122 @@ func TestReplaceParam(t *testing.T) {
 	}
 
 }
+
+func TestTaskURL(t *testing.T) {
+	srvaddr = "http://127.0.0.1:2142"
+	u := &User{}
+	u.param = map[string]string{
+		"GAME_SN": "12345",
+	}
+	u.hosts = map[string]string{
+		"auth": "https://auth.example.com",
+	}
+
+	{
+		dest := u.taskURL(&Task{URL: "/x/game/[GAME_SN]"})
+		if dest != "http://127.0.0.1:2142/x/game/12345" {
+			t.Error("url err", dest)
+		}
+	}
+	{
+		dest := u.taskURL(&Task{URL: "/login", Host: "auth"})
+		if dest != "https://auth.example.com/login" {
+			t.Error("url err", dest)
+		}
+	}
+	{
+		dest := u.taskURL(&Task{URL: "https://cdn.example.com/[GAME_SN].png"})
+		if dest != "https://cdn.example.com/12345.png" {
+			t.Error("url err", dest)
+		}
+	}
+}
+
+func TestCookieParam(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "SID", Value: "abcd"})
+			return
+		}
+		if c, err := r.Cookie("SID"); err != nil || c.Value != "abcd" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	u := &User{param: map[string]string{}, client: newHTTPClient()}
+	u.resetCookies()
+
+	res, err := doRequest(u.client, srv.URL+"/login", "POST", nil, nil, nil, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.setParam(map[string]string{"[SESSION]": "cookie:SID"}, res.parsed, srv.URL+"/login")
+	if u.param["SESSION"] != "abcd" {
+		t.Error("cookie param err", u.param)
+	}
+	if _, err := doRequest(u.client, srv.URL+"/info", "GET", nil, nil, nil, nil, "", nil); err != nil {
+		t.Error("cookie not sent", err)
+	}
+}
+
+func TestClearCookiesKeepPre(t *testing.T) {
+	var failed []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "SID", Value: "abcd"})
+		case "/cart":
+			http.SetCookie(w, &http.Cookie{Name: "CART", Value: "1"})
+		case "/check":
+			if _, err := r.Cookie("SID"); err != nil {
+				failed = append(failed, "no SID")
+			}
+			if _, err := r.Cookie("CART"); err == nil {
+				failed = append(failed, "CART of the last iteration")
+			}
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	statsAggregator = make(chan *RequesterStats, 10)
+	u := &User{param: map[string]string{}, client: newHTTPClient(), clearCookies: true}
+	u.resetCookies()
+	u.Pre([]*Task{{Step: "login", URL: srv.URL + "/login", Method: "POST"}})
+
+	run := []*Task{{Step: "check", URL: srv.URL + "/check"}, {Step: "cart", URL: srv.URL + "/cart"}}
+	for i := 0; i < 2; i++ {
+		u.Run(run, nil)
+		for range run {
+			<-statsAggregator
+		}
+	}
+	if len(failed) > 0 {
+		t.Error("run cookies", failed)
+	}
+}
+
+func TestSendStatsHostCopy(t *testing.T) {
+	statsAggregator = make(chan *RequesterStats, 10)
+	u := &User{hostStats: true}
+	stats := &RequesterStats{Title: "info", MinRequestTime: time.Minute}
+	stats.Calc(&reqResult{proto: "HTTP/1.1"})
+	stats.Err(&graphqlError{errors: []interface{}{"bad"}})
+	u.sendStats(stats, "http://api.local/info")
+
+	step, host := <-statsAggregator, <-statsAggregator
+	// the aggregator add the later stats of a title into the first one.
+	step.Add(step.clone())
+	if host.Title != "host api.local" || host.Protos["HTTP/1.1"] != 1 || host.NumErrs != 1 || len(host.ErrCategories) != 1 {
+		t.Errorf("host stats %+v", host)
+	}
+	for _, n := range host.ErrCategories {
+		if n != 1 {
+			t.Error("host err categories shared", host.ErrCategories)
+		}
+	}
+	if step.Protos["HTTP/1.1"] != 2 {
+		t.Error("step stats", step.Protos)
+	}
+}
@@ -3,7 +3,7 @@ package main
 import (
 	"fmt"
 	"net"
-	"net/http"
+	"strings"
 )
 
 // RedirectError specific error type that happens on redirection
@@ -55,20 +55,13 @@ func (bs ByteSize) String() string {
 	return srt
 }
 
-//EstimateHTTPHeadersSize had to create this because headers size was not counted
-func EstimateHTTPHeadersSize(headers http.Header) (result int64) {
-	result = 0
-
-	for k, v := range headers {
-		result += int64(len(k) + len(": \r\n"))
-		for _, s := range v {
-			result += int64(len(s))
+func isAbsURL(str string) bool {
+	for _, scheme := range []string{"http://", "https://", "ws://", "wss://", "tcp://", "udp://"} {
+		if strings.HasPrefix(str, scheme) {
+			return true
 		}
 	}
-
-	result += int64(len("\r\n"))
-
-	return result
+	return false
 }
 
 // GetMyIP ...
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	paramRefRegexp = regexp.MustCompile(`\[([^\[\]]+)\]`)
+	// templateRefRegexp a [KEY] of a message, match or variables, where json arrays like [1,2] are kept.
+	templateRefRegexp = regexp.MustCompile(`\[([A-Za-z_][A-Za-z0-9_]*)\]`)
+
+	validEncodings = map[string]bool{
+		EncodingGzip:    true,
+		EncodingDeflate: true,
+		EncodingBrotli:  true,
+	}
+	validMethods = map[string]bool{
+		"":        true, // GET
+		"GET":     true,
+		"POST":    true,
+		"PUT":     true,
+		"PATCH":   true,
+		"DELETE":  true,
+		"HEAD":    true,
+		"OPTIONS": true,
+	}
+)
+
+// validator collect scenario problems in run order.
+type validator struct {
+	defined map[string]bool
+	seen    map[string]bool
+	hosts   map[string]string
+	errs    []error
+
+	// sockUDP the last socket_connect is udp, where a datagram is a frame.
+	sockUDP bool
+}
+
+func (v *validator) errorf(format string, args ...interface{}) {
+	err := fmt.Errorf(format, args...)
+	if v.seen[err.Error()] {
+		return
+	}
+	v.seen[err.Error()] = true
+	v.errs = append(v.errs, err)
+}
+
+// Validate statically check the scenario and return every problem found.
+func (s *Scenario) Validate() []error {
+	v := &validator{
+		defined: map[string]bool{"STEP_NAME": true},
+		seen:    make(map[string]bool),
+		hosts:   s.Hosts,
+	}
+
+	keys := make([]string, 0, len(s.Param))
+	for key := range s.Param {
+		v.defined[key] = true
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v.checkParamValue(key, s.Param[key])
+	}
+
+	// with the auth section the token is obtained at the first use_token task.
+	if s.Auth != nil {
+		v.defined["ACCESS_TOKEN"] = true
+	}
+	// the auth refs are checked against the params defined before the first use_token task.
+	authChecked := s.Auth == nil
+	for i, task := range s.Pre {
+		if task.UseToken && !authChecked {
+			v.checkAuth(s.Auth)
+			authChecked = true
+		}
+		v.checkTask(task, "pre", i, true)
+	}
+	if !authChecked {
+		v.checkAuth(s.Auth)
+	}
+	if len(s.Run) == 0 {
+		for i, step := range s.PreStep {
+			v.checkTask(step, "pre_step", i, false)
+		}
+	}
+	for i, task := range s.Run {
+		// pre_step run before every run task.
+		for j, step := range s.PreStep {
+			v.checkTask(step, "pre_step", j, false)
+		}
+		v.checkTask(task, "run", i, true)
+	}
+	return v.errs
+}
+
+// checkLoad reject the tasks that would hang or panic at run. Validate check the rest under -check.
+func (s *Scenario) checkLoad() error {
+	for _, tasks := range [][]*Task{s.Pre, s.Run, s.PreStep, s.authTasks()} {
+		for _, task := range tasks {
+			if isStreamTask(task) && task.Events <= 0 && task.DurationSec <= 0 {
+				return fmt.Errorf("%s: %s without events or duration_sec", task.origin, task.Type)
+			}
+			if task.LengthPrefix < 0 || task.LengthPrefix > 8 {
+				return fmt.Errorf("%s: length_prefix %d want 1 to 8 bytes", task.origin, task.LengthPrefix)
+			}
+			for key := range task.SetParam {
+				if !isParamKey(key) {
+					return fmt.Errorf("%s: set_param key %q must be [KEY] form", task.origin, key)
+				}
+			}
+			for key := range task.Fields {
+				if !isParamKey(key) {
+					return fmt.Errorf("%s: fields key %q must be [KEY] form", task.origin, key)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (v *validator) checkParamValue(key, val string) {
+	startIdx := strings.Index(val, "[")
+	endIdx := strings.Index(val, "]")
+	if startIdx == -1 || endIdx == -1 {
+		return
+	}
+	if endIdx < startIdx {
+		v.errorf("param %s: invalid value %q", key, val)
+		return
+	}
+
+	valList := strings.Split(val[startIdx+1:endIdx], ":")
+	switch valList[0] {
+	case "RAND", "STEP":
+		if len(valList) != 3 {
+			v.errorf("param %s: %q want [%s:min:max]", key, val, valList[0])
+			return
+		}
+		min, err1 := strconv.Atoi(valList[1])
+		max, err2 := strconv.Atoi(valList[2])
+		if err1 != nil || err2 != nil {
+			v.errorf("param %s: %q min and max must be integer", key, val)
+		} else if min >= max {
+			v.errorf("param %s: %q min must be less than max", key, val)
+		} else if valList[0] == "STEP" && min == 0 {
+			v.errorf("param %s: %q STEP min must not be 0", key, val)
+		}
+	default:
+		v.errorf("param %s: not support param %q", key, val)
+	}
+}
+
+func (v *validator) checkTask(task *Task, section string, idx int, needToken bool) {
+	where := task.origin
+	if where == "" {
+		where = fmt.Sprintf("%s[%d]", section, idx)
+	}
+	if task.Step != "" {
+		where += " (" + task.Step + ")"
+	}
+
+	if !validMethods[task.Method] {
+		v.errorf("%s: invalid method %q", where, task.Method)
+	}
+	if err := checkWaitSec(task.WaitSec); err != nil {
+		v.errorf("%s: wait_sec: %s", where, err)
+	}
+	if task.Compress != "" && !validEncodings[task.Compress] {
+		v.errorf("%s: invalid compress %q", where, task.Compress)
+	}
+	switch task.Type {
+	case "":
+	case WSConnect:
+		if task.URL == "" {
+			v.errorf("%s: %s without url", where, task.Type)
+		}
+	case WSSend, WSWait, WSClose:
+		if task.URL != "" {
+			v.errorf("%s: %s use the url of ws_connect, not %q", where, task.Type, task.URL)
+		}
+		if task.Type == WSSend && task.Message == "" {
+			v.errorf("%s: ws_send without message", where)
+		}
+	case TaskGRPC:
+		if i := strings.LastIndex(task.Call, "/"); i <= 0 || i == len(task.Call)-1 {
+			v.errorf("%s: grpc call %q want package.Service/Method", where, task.Call)
+		}
+	case SocketConnect, SocketSend, SocketRead, SocketClose:
+		if task.Type == SocketConnect {
+			if !strings.HasPrefix(task.URL, "tcp://") && !strings.HasPrefix(task.URL, "udp://") && task.Host == "" {
+				v.errorf("%s: socket_connect url %q want tcp:// or udp://", where, task.URL)
+			}
+			v.sockUDP = strings.HasPrefix(task.URL, "udp://")
+		} else if task.URL != "" {
+			v.errorf("%s: %s use the url of socket_connect, not %q", where, task.Type, task.URL)
+		}
+		if task.Type == SocketSend && task.Message == "" {
+			v.errorf("%s: socket_send without message", where)
+		}
+		if task.Delimiter != "" && v.sockUDP {
+			v.errorf("%s: delimiter is not supported on udp, a datagram is a frame", where)
+		}
+		switch task.Encoding {
+		case "", PayloadText, PayloadHex, PayloadBase64:
+		default:
+			v.errorf("%s: invalid encoding %q", where, task.Encoding)
+		}
+		if task.LengthPrefix < 0 || task.LengthPrefix > 8 {
+			v.errorf("%s: length_prefix %d want 1 to 8 bytes", where, task.LengthPrefix)
+		}
+		for k, spec := range task.Fields {
+			if !isParamKey(k) {
+				v.errorf("%s: fields key %q must be [KEY] form", where, k)
+				continue
+			}
+			if _, err := parseField(spec); err != nil {
+				v.errorf("%s: fields %s: %s", where, k, err)
+			}
+			v.defined[k[1:len(k)-1]] = true
+		}
+	case TaskGraphQL:
+		if task.URL == "" {
+			v.errorf("%s: graphql without url", where)
+		}
+		if task.Query == "" {
+			v.errorf("%s: graphql without query", where)
+		}
+		if task.Method != "" && task.Method != "POST" {
+			v.errorf("%s: graphql is sent by POST, not %s", where, task.Method)
+		}
+	case StreamSSE, StreamPoll:
+		if task.URL == "" {
+			v.errorf("%s: %s without url", where, task.Type)
+		}
+		if task.Events <= 0 && task.DurationSec <= 0 {
+			v.errorf("%s: %s without events or duration_sec", where, task.Type)
+		}
+	default:
+		v.errorf("%s: invalid type %q", where, task.Type)
+	}
+
+	if task.Host != "" {
+		if _, ok := v.hosts[task.Host]; !ok {
+			v.errorf("%s: unknown host %q", where, task.Host)
+		} else if isAbsURL(task.URL) {
+			v.errorf("%s: host %q with absolute url", where, task.Host)
+		}
+	}
+
+	if task.URL != "" {
+		v.checkRefs(where, "url", task.URL)
+		for k, val := range task.URLParam {
+			v.checkRefs(where, "url_param."+k, val)
+		}
+		for k, val := range task.Body {
+			v.checkRefs(where, "body."+k, val)
+		}
+		for k, p := range task.Files {
+			if err := p.check(); err != nil {
+				v.errorf("%s: files.%s: %s", where, k, err)
+			}
+			v.checkRefs(where, "files."+k+".name", p.Name)
+		}
+		// pre_step skip token task when there is no token yet.
+		if task.UseToken && needToken && !v.defined["ACCESS_TOKEN"] {
+			v.errorf("%s: use_token but ACCESS_TOKEN is not defined", where)
+		}
+	}
+
+	v.checkTemplateRefs(where, "message", task.Message)
+	for k, val := range task.Match {
+		v.checkTemplateRefs(where, "match."+k, val)
+	}
+	if task.Variables != nil {
+		v.checkVariables(where, "variables", task.Variables)
+	}
+
+	for k := range task.SetParam {
+		if !isParamKey(k) {
+			v.errorf("%s: set_param key %q must be [KEY] form", where, k)
+			continue
+		}
+		v.defined[k[1:len(k)-1]] = true
+	}
+}
+
+// checkTemplateRefs check the refs replaced by replaceTemplate. true, false and null are json, not params.
+func (v *validator) checkTemplateRefs(where, field, val string) {
+	for _, m := range templateRefRegexp.FindAllStringSubmatch(val, -1) {
+		switch m[1] {
+		case "true", "false", "null":
+			continue
+		}
+		if !v.defined[m[1]] {
+			v.errorf("%s: %s: undefined param [%s]", where, field, m[1])
+		}
+	}
+}
+
+// checkVariables check the refs of the strings in graphql variables.
+func (v *validator) checkVariables(where, field string, val interface{}) {
+	switch val := val.(type) {
+	case string:
+		if m := jsonRefRegexp.FindStringSubmatch(val); m != nil {
+			v.checkRefs(where, field, "["+m[1]+"]")
+			return
+		}
+		v.checkTemplateRefs(where, field, val)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v.checkVariables(where, field+"."+k, val[k])
+		}
+	case []interface{}:
+		for i, item := range val {
+			v.checkVariables(where, fmt.Sprintf("%s[%d]", field, i), item)
+		}
+	}
+}
+
+func (v *validator) checkRefs(where, field, val string) {
+	for _, m := range paramRefRegexp.FindAllStringSubmatch(val, -1) {
+		if !v.defined[m[1]] {
+			v.errorf("%s: %s: undefined param [%s]", where, field, m[1])
+		}
+	}
+}
+
+// checkWaitSec validate the wait_sec format parsed by parseWaitSec.
+func checkWaitSec(str string) error {
+	if str == "" {
+		return nil
+	}
+	ps := strings.Split(str, ":")
+	if len(ps) > 2 {
+		return fmt.Errorf("%q want sec or min:max", str)
+	}
+	vals := make([]int, len(ps))
+	for i, p := range ps {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%q is not a positive integer", p)
+		}
+		vals[i] = n
+	}
+	if len(vals) == 2 && vals[0] >= vals[1] {
+		return fmt.Errorf("%q min must be less than max", str)
+	}
+	return nil
+}
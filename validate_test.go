@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	s := &Scenario{
+		Param: map[string]string{
+			"USER_ID": "[RAND:10:1]",
+			"GAME":    "[FOO:1:2]",
+		},
+		Pre: []*Task{
+			{Step: "login", URL: "/login/[USER_ID]", Method: "POST", SetParam: map[string]string{"[ACCESS_TOKEN]": "token"}},
+		},
+		Run: []*Task{
+			{Step: "start", URL: "/game/[GAME_SN]", Method: "PUSH", UseToken: true, SetParam: map[string]string{"GAME_SN": "sn"}},
+			{Step: "end", URL: "/game/end", WaitSec: "5:1"},
+		},
+	}
+
+	errs := s.Validate()
+	want := []string{
+		"param GAME: not support param",
+		"param USER_ID: \"[RAND:10:1]\" min must be less than max",
+		"run[0] (start): invalid method \"PUSH\"",
+		"run[0] (start): url: undefined param [GAME_SN]",
+		"run[0] (start): set_param key \"GAME_SN\" must be [KEY] form",
+		"run[1] (end): wait_sec: \"5:1\" min must be less than max",
+	}
+	if len(errs) != len(want) {
+		t.Fatal("problem count err", errs)
+	}
+	for i, w := range want {
+		if !strings.Contains(errs[i].Error(), w) {
+			t.Errorf("problem %d err got=%s want=%s", i, errs[i], w)
+		}
+	}
+}
+
+func TestValidateOK(t *testing.T) {
+	s := &Scenario{
+		Param: map[string]string{"USER_ID": "[STEP:1:100]"},
+		Pre: []*Task{
+			{Step: "login", URL: "/login/[USER_ID]", Method: "POST", SetParam: map[string]string{"[ACCESS_TOKEN]": "token"}},
+		},
+		PreStep: []*Task{{URL: "/ping/[STEP_NAME]", UseToken: true}},
+		Run: []*Task{
+			{Step: "info", URL: "/info", UseToken: true, WaitSec: "1:3"},
+		},
+	}
+	if errs := s.Validate(); len(errs) > 0 {
+		t.Error("unexpected problem", errs)
+	}
+}
+
+func TestValidateTemplateRefs(t *testing.T) {
+	s := &Scenario{
+		Param: map[string]string{"NICK": "bust"},
+		Run: []*Task{
+			{Step: "join", Type: WSConnect, URL: "/ws"},
+			{Step: "say", Type: WSSend, Message: `{"nick":"[NICK]","room":"[ROOM]","ids":[1,2],"ok":[true]}`},
+			{Step: "wait", Type: WSWait, Match: map[string]string{"room": "[ROOM_ID]"}},
+			{Step: "rank", Type: TaskGraphQL, URL: "/graphql", Query: "query($ids: [ID!]) { rank(ids: $ids) }",
+				Variables: map[string]interface{}{"ids": []interface{}{"[SEASON]"}, "limit": "[LIMIT:json]", "nick": "[NICK:json]"}},
+		},
+	}
+	errs := s.Validate()
+	want := []string{
+		"run[1] (say): message: undefined param [ROOM]",
+		"run[2] (wait): match.room: undefined param [ROOM_ID]",
+		"run[3] (rank): variables.ids[0]: undefined param [SEASON]",
+		"run[3] (rank): variables.limit: undefined param [LIMIT]",
+	}
+	if len(errs) != len(want) {
+		t.Fatal("problem count err", errs)
+	}
+	for i, w := range want {
+		if !strings.Contains(errs[i].Error(), w) {
+			t.Errorf("problem %d err got=%s want=%s", i, errs[i], w)
+		}
+	}
+}
+
+func TestValidateSocket(t *testing.T) {
+	s := &Scenario{
+		Run: []*Task{
+			{Step: "open", Type: SocketConnect, URL: "udp://127.0.0.1:9000", Encoding: "utf16"},
+			{Step: "read", Type: SocketRead, Delimiter: "\n"},
+			{Step: "reopen", Type: SocketConnect, URL: "tcp://127.0.0.1:9000", LengthPrefix: 9},
+			{Step: "line", Type: SocketRead, Delimiter: "\n"},
+		},
+	}
+	errs := s.Validate()
+	want := []string{
+		"run[0] (open): invalid encoding \"utf16\"",
+		"run[1] (read): delimiter is not supported on udp",
+		"run[2] (reopen): length_prefix 9 want 1 to 8 bytes",
+	}
+	if len(errs) != len(want) {
+		t.Fatal("problem count err", errs)
+	}
+	for i, w := range want {
+		if !strings.Contains(errs[i].Error(), w) {
+			t.Errorf("problem %d err got=%s want=%s", i, errs[i], w)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// websocket task type
+const (
+	WSConnect = "ws_connect"
+	WSSend    = "ws_send"
+	WSWait    = "ws_wait"
+	WSClose   = "ws_close"
+)
+
+const defaultTaskTimeout = 10 * time.Second
+
+// wsConn the websocket connection of a user.
+type wsConn struct {
+	conn     *websocket.Conn
+	url      string
+	start    time.Time
+	lastSend time.Time
+}
+
+func isWSTask(task *Task) bool {
+	switch task.Type {
+	case WSConnect, WSSend, WSWait, WSClose:
+		return true
+	}
+	return false
+}
+
+// wsURL return reqURL with the http scheme changed to ws.
+func wsURL(reqURL string) string {
+	if strings.HasPrefix(reqURL, "https://") {
+		return "wss://" + reqURL[len("https://"):]
+	}
+	if strings.HasPrefix(reqURL, "http://") {
+		return "ws://" + reqURL[len("http://"):]
+	}
+	return reqURL
+}
+
+// taskTimeout return the timeout_sec of a websocket, grpc or socket task, default 10 seconds.
+func taskTimeout(task *Task) time.Duration {
+	if task.TimeoutSec > 0 {
+		return time.Duration(task.TimeoutSec) * time.Second
+	}
+	return defaultTaskTimeout
+}
+
+// replaceTemplate replace every [KEY] of a defined param. other brackets, like json arrays, are kept.
+func (u *User) replaceTemplate(str string) string {
+	return paramRefRegexp.ReplaceAllStringFunc(str, func(ref string) string {
+		if val, ok := u.param[ref[1:len(ref)-1]]; ok {
+			return val
+		}
+		return ref
+	})
+}
+
+// doWS run a websocket task on the connection of the user.
+// a failed task close the connection, so the next ws_connect start again.
+func (u *User) doWS(task *Task, reqURL string, headerList map[string]string, tr *TraceEntry) (*reqResult, error) {
+	start := time.Now()
+	if tr != nil {
+		tr.Start = start
+		tr.Method = strings.ToUpper(task.Type)
+		tr.URL = reqURL
+		tr.Proto = "websocket"
+	}
+
+	wire := &wireCount{}
+	res := &reqResult{proto: "websocket"}
+	var err error
+	if task.Type == WSConnect {
+		err = u.wsConnect(reqURL, headerList, task, wire)
+	} else if u.ws == nil {
+		err = fmt.Errorf("%s: websocket not connected", task.Type)
+	} else {
+		if c := unwrapCountConn(u.ws.conn.UnderlyingConn()); c != nil {
+			c.owner.Store(wire)
+		}
+		switch task.Type {
+		case WSSend:
+			err = u.wsSend(task, tr)
+		case WSWait:
+			res.parsed, res.wsMessage, err = u.wsWait(task, tr)
+		case WSClose:
+			res.wsConn = time.Since(u.ws.start)
+			err = u.wsClose()
+		}
+	}
+	if err != nil && u.ws != nil {
+		u.ws.conn.Close()
+		u.ws = nil
+	}
+
+	res.total = time.Since(start)
+	res.duration = res.total
+	if res.wsMessage > 0 {
+		res.duration = res.wsMessage
+	}
+	res.sent = atomic.LoadInt64(&wire.sent)
+	res.recv = atomic.LoadInt64(&wire.recv)
+	if tr != nil {
+		tr.Duration = res.total
+	}
+	return res, err
+}
+
+func (u *User) wsConnect(reqURL string, headerList map[string]string, task *Task, wire *wireCount) error {
+	if u.ws != nil {
+		u.ws.conn.Close()
+		u.ws = nil
+	}
+
+	dialer := &websocket.Dialer{
+		NetDialContext:   newDialFunc(u.local),
+		Proxy:            transportProxy(),
+		TLSClientConfig:  tlsConfig,
+		HandshakeTimeout: taskTimeout(task),
+		Jar:              u.client.Jar,
+	}
+	if parsed, err := url.Parse(reqURL); err == nil {
+		if cfg, ok := hostTLSConfig[parsed.Host]; ok {
+			dialer.TLSClientConfig = cfg
+		}
+	}
+	header := make(http.Header)
+	for key, val := range headerList {
+		header.Set(key, val)
+	}
+
+	ctx := withWire(context.Background(), wire)
+	conn, resp, err := dialer.DialContext(ctx, reqURL, header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("ws connect status code err=%d %s", resp.StatusCode, err)
+		}
+		return fmt.Errorf("ws connect %s", err)
+	}
+	u.ws = &wsConn{conn: conn, url: reqURL, start: time.Now()}
+	return nil
+}
+
+func (u *User) wsSend(task *Task, tr *TraceEntry) error {
+	msg := u.replaceTemplate(task.Message)
+	if tr != nil {
+		tr.ReqBody = msg
+	}
+
+	msgType := websocket.TextMessage
+	data := []byte(msg)
+	if task.Binary {
+		decoded, err := base64.StdEncoding.DecodeString(msg)
+		if err != nil {
+			return fmt.Errorf("ws_send binary message not base64 %s", err)
+		}
+		msgType, data = websocket.BinaryMessage, decoded
+	}
+
+	u.ws.conn.SetWriteDeadline(time.Now().Add(taskTimeout(task)))
+	if err := u.ws.conn.WriteMessage(msgType, data); err != nil {
+		return fmt.Errorf("ws send %s", err)
+	}
+	u.ws.lastSend = time.Now()
+	return nil
+}
+
+// wsWait read messages until one match all conditions of the task. the latency is from the last send,
+// or from the wait when nothing was sent.
+func (u *User) wsWait(task *Task, tr *TraceEntry) (map[string]interface{}, time.Duration, error) {
+	start := time.Now()
+	if u.ws.lastSend.After(u.ws.start) {
+		start = u.ws.lastSend
+	}
+	u.ws.conn.SetReadDeadline(time.Now().Add(taskTimeout(task)))
+	for {
+		_, data, err := u.ws.conn.ReadMessage()
+		if err != nil {
+			if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() {
+				return nil, 0, fmt.Errorf("ws wait timeout %v", taskTimeout(task))
+			}
+			return nil, 0, fmt.Errorf("ws wait %s", err)
+		}
+		latency := time.Since(start)
+
+		parsed, ok := u.wsMatch(task.Match, data)
+		if !ok {
+			continue
+		}
+		if tr != nil {
+			tr.RespBody = string(data)
+		}
+		u.ws.lastSend = time.Time{}
+		return parsed, latency, nil
+	}
+}
+
+// wsMatch parse a received message as json and check "a.b.c" keys of match equal the templated values.
+func (u *User) wsMatch(match map[string]string, data []byte) (map[string]interface{}, bool) {
+	var parsed map[string]interface{}
+	jsonErr := json.Unmarshal(data, &parsed)
+	if len(match) == 0 {
+		return parsed, true
+	}
+	if jsonErr != nil {
+		return nil, false
+	}
+	for key, want := range match {
+		val, ok := lookupPath(parsed, key)
+		if !ok || valueString(val) != u.replaceTemplate(want) {
+			return nil, false
+		}
+	}
+	return parsed, true
+}
+
+func (u *User) wsClose() error {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	err := u.ws.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	u.ws.conn.Close()
+	u.ws = nil
+	if err != nil {
+		return fmt.Errorf("ws close %s", err)
+	}
+	return nil
+}
+
+// lookupPath return the value of "a.b.c" in parsed json.
+func lookupPath(parsed map[string]interface{}, path string) (interface{}, bool) {
+	node := parsed
+	keys := strings.Split(path, ".")
+	for i, key := range keys {
+		val, ok := node[key]
+		if !ok {
+			return nil, false
+		}
+		if i == len(keys)-1 {
+			return val, true
+		}
+		if node, ok = val.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// valueString format a json value like setParam.
+func valueString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(val)
+}
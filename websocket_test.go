@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketTasks(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"noise"}`))
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"joined","room":{"id":7},"echo":`+string(data)+`}`))
+		}
+	}))
+	defer srv.Close()
+
+	u := &User{param: map[string]string{"ACCESS_TOKEN": "tok", "NICK": "bust"}, client: newHTTPClient()}
+	u.resetCookies()
+	defer u.Close()
+
+	run := func(task *Task) *reqResult {
+		res, err := u.doTask(task, u.requestURL(task), u.makeHeader(task.UseToken), nil)
+		if err != nil {
+			t.Fatal(task.Type, err)
+		}
+		return res
+	}
+
+	srvaddr = srv.URL
+	run(&Task{Type: WSConnect, URL: "/ws", UseToken: true})
+	if u.ws == nil || u.ws.url != "ws"+srv.URL[len("http"):]+"/ws" {
+		t.Fatal("not connected", u.ws)
+	}
+	run(&Task{Type: WSSend, Message: `{"nick":"[NICK]","list":[1,2]}`})
+	res := run(&Task{Type: WSWait, Match: map[string]string{"type": "joined", "echo.nick": "[NICK]"}})
+	if res.wsMessage <= 0 || res.recv == 0 {
+		t.Errorf("wait result %+v", res)
+	}
+	u.setParam(map[string]string{"[ROOM_ID]": "room.id"}, res.parsed, u.ws.url)
+	if u.param["ROOM_ID"] != "7" {
+		t.Error("room id not set", u.param)
+	}
+
+	if _, err := u.doTask(&Task{Type: WSWait, Match: map[string]string{"type": "never"}, TimeoutSec: 1}, u.ws.url, nil, nil); err == nil {
+		t.Error("wait not timed out")
+	}
+	if u.ws != nil {
+		t.Error("failed connection not dropped")
+	}
+
+	run(&Task{Type: WSConnect, URL: "/ws", UseToken: true})
+	if res := run(&Task{Type: WSClose}); res.wsConn <= 0 {
+		t.Error("connection duration not set")
+	}
+}
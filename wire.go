@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+)
+
+// wireCount bytes sent and received on the connections of a request.
+type wireCount struct {
+	sent int64
+	recv int64
+}
+
+type wireCountKey struct{}
+
+// withWire return ctx counting the connection bytes into w.
+// a new connection count from the dial, so the tls handshake and proxy connect are included.
+func withWire(ctx context.Context, w *wireCount) context.Context {
+	ctx = context.WithValue(ctx, wireCountKey{}, w)
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if tlsConn, ok := info.Conn.(*tls.Conn); ok && tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+				return
+			}
+			if c := unwrapCountConn(info.Conn); c != nil {
+				c.owner.Store(w)
+			}
+		},
+	})
+}
+
+// countConn net.Conn counting the bytes into the request which got the connection last.
+// http2 streams share the connection, so their bytes are counted by wireResult instead.
+type countConn struct {
+	net.Conn
+	owner atomic.Pointer[wireCount]
+}
+
+// newCountConn wrap conn counting into the wireCount of ctx.
+func newCountConn(ctx context.Context, conn net.Conn) *countConn {
+	c := &countConn{Conn: conn}
+	if w, ok := ctx.Value(wireCountKey{}).(*wireCount); ok {
+		c.owner.Store(w)
+	}
+	return c
+}
+
+func (c *countConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if w := c.owner.Load(); w != nil && n > 0 {
+		atomic.AddInt64(&w.recv, int64(n))
+	}
+	return n, err
+}
+
+func (c *countConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if w := c.owner.Load(); w != nil && n > 0 {
+		atomic.AddInt64(&w.sent, int64(n))
+	}
+	return n, err
+}
+
+// setWire set the bytes of a request. http/1.1 has the connection to itself and count every byte of it.
+// the headers and frames of h2 streams sharing a connection can not be attributed to a step,
+// so an h2 request count only its request and response body, apart from the wire bytes.
+func (res *reqResult) setWire(resp *http.Response, wire *wireCount, bodySent, bodyRecv int64) {
+	if resp != nil && resp.ProtoMajor == 2 {
+		res.h2Sent, res.h2Recv = bodySent, bodyRecv
+		return
+	}
+	res.sent, res.recv = atomic.LoadInt64(&wire.sent), atomic.LoadInt64(&wire.recv)
+}
+
+func unwrapCountConn(conn net.Conn) *countConn {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	c, _ := conn.(*countConn)
+	return c
+}
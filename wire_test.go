@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWireCount(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"result":"ok"}`))
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: newTLSTransport(&tls.Config{}, newDialFunc(nil))}
+	body := map[string]string{"data": strings.Repeat("x", 500)}
+	for i := 0; i < 2; i++ {
+		res, err := doRequest(client, srv.URL, "POST", nil, nil, body, nil, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the response line and headers are counted, not only the body.
+		if res.sent <= 500 || res.recv <= int64(res.respSize) {
+			t.Errorf("request %d sent %d recv %d", i, res.sent, res.recv)
+		}
+	}
+
+	res, err := doRequest(client, srv.URL+"/fail", "GET", nil, nil, nil, nil, "", nil)
+	if err == nil || res == nil || res.recv == 0 {
+		t.Errorf("error status not counted res=%+v err=%v", res, err)
+	}
+}
+
+func TestWireCountHTTP2(t *testing.T) {
+	// the two requests are in the handler at once, so they are concurrent streams of one connection.
+	var arrived int32
+	both := make(chan struct{})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/warm" {
+			if atomic.AddInt32(&arrived, 1) == 2 {
+				close(both)
+			}
+			<-both
+		}
+		ioutil.ReadAll(r.Body)
+		w.Write([]byte(strings.Repeat("y", len(r.URL.Path)*1000)))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	setHTTP2(t, "", 0)
+	client := &http.Client{Transport: newTLSTransport(&tls.Config{InsecureSkipVerify: true}, newDialFunc(nil))}
+	before := atomic.LoadInt64(&connOpened)
+	client.Get(srv.URL + "/warm")
+
+	results := make([]*reqResult, 2)
+	var wg sync.WaitGroup
+	for i, path := range []string{"/a", "/bbbb"} {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			body := map[string]string{"d": strings.Repeat("x", (i+1)*300)}
+			results[i], _ = doRequest(client, srv.URL+path, "POST", nil, nil, body, nil, "", nil)
+		}(i, path)
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt64(&connOpened) - before; n != 1 {
+		t.Fatal("streams not on one connection, opened", n)
+	}
+	for i, res := range results {
+		if res == nil || res.proto != "HTTP/2.0" {
+			t.Fatalf("request %d result %+v", i, res)
+		}
+		// d=xxx.. and the body of /a or /bbbb
+		if wantSent, wantRecv := int64(2+(i+1)*300), int64((2+i*3)*1000); res.h2Sent != wantSent || res.h2Recv != wantRecv || res.sent != 0 || res.recv != 0 {
+			t.Errorf("request %d h2 body %d %d wire %d %d want %d %d", i, res.h2Sent, res.h2Recv, res.sent, res.recv, wantSent, wantRecv)
+		}
+	}
+}
+
+func TestPrintHTTP2Body(t *testing.T) {
+	rs := &RequesterStats{Title: "h2", MinRequestTime: time.Minute}
+	rs.Calc(&reqResult{duration: time.Millisecond, proto: "HTTP/2.0", h2Sent: 10, h2Recv: 2048})
+	out := rs.PrintResult(1)
+	if !strings.Contains(out, "0.00bytes read") || !strings.Contains(out, "HTTP/2 Body:\t\t2.00KB read, 10.00bytes written") {
+		t.Error("h2 bodies not apart from the wire bytes", out)
+	}
+	if csv := rs.PrintCSV(1); strings.Count(csv, ",") != strings.Count(PrintCsvHeader(), ",") || !strings.HasSuffix(csv, ",2048,10\n") {
+		t.Error("csv", csv)
+	}
+}